@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/sourcegraph/go-vcsurl"
 	"github.com/tcard/navpatch/internal"
@@ -15,18 +16,38 @@ import (
 )
 
 func main() {
-	listenAddr, baseDir, rawPatch := processArgs()
+	listenAddr, exportDir, baseDir, rawPatch, ws := processArgs()
 
 	r, err := buildRepository(baseDir)
 	if err != nil {
 		internal.ErrorExit(err)
 	}
 
-	nav, err := navpatch.NewNavigator(r, rawPatch)
+	nav, err := navpatch.NewNavigatorIgnoring(r, rawPatch, ws)
 	if err != nil {
 		internal.ErrorExit(err)
 	}
 
+	switch listenAddr {
+	case "tui":
+		err = nav.RunTerminal()
+		if err != nil {
+			internal.ErrorExit(err)
+		}
+		return
+	case "export":
+		if strings.HasSuffix(exportDir, ".html") {
+			err = exportBundle(nav, exportDir)
+		} else {
+			err = nav.Export(exportDir)
+		}
+		if err != nil {
+			internal.ErrorExit("exporting:", err)
+		}
+		fmt.Println("Exported to " + exportDir)
+		return
+	}
+
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		internal.ErrorExit("starting server:", err)
@@ -36,6 +57,18 @@ func main() {
 	log.Fatal(http.Serve(listener, nav))
 }
 
+func exportBundle(nav *navpatch.Navigator, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = nav.ExportBundle(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func buildRepository(path string) (navpatch.Repository, error) {
 	if _, err := os.Stat(path); err == nil {
 		return repositories.NewFSRepository(path), nil
@@ -48,8 +81,28 @@ func buildRepository(path string) (navpatch.Repository, error) {
 	return nil, fmt.Errorf("invalid path or VCS url: %s", path)
 }
 
-func processArgs() (string, string, []byte) {
+func processArgs() (string, string, string, []byte, navpatch.Whitespace) {
 	args := os.Args
+
+	ws := navpatch.WhitespaceExact
+	for len(args) > 1 && (args[1] == "-w" || args[1] == "-b") {
+		if args[1] == "-w" {
+			ws = navpatch.IgnoreAllSpace
+		} else if ws != navpatch.IgnoreAllSpace {
+			ws = navpatch.IgnoreSpaceChange
+		}
+		args = append([]string{args[0]}, args[2:]...)
+	}
+
+	exportDir := ""
+	if len(args) > 1 && args[1] == "export" {
+		if len(args) < 4 || args[2] != "-o" {
+			badArgs()
+		}
+		exportDir = args[3]
+		args = append([]string{args[0], args[1]}, args[4:]...)
+	}
+
 	if len(args) < 2 || len(args) > 4 {
 		badArgs()
 	}
@@ -78,7 +131,7 @@ func processArgs() (string, string, []byte) {
 		internal.ErrorExit(err)
 	}
 
-	return args[1], args[2], rawPatch
+	return args[1], exportDir, args[2], rawPatch, ws
 }
 
 func badArgs() {
@@ -88,16 +141,26 @@ func badArgs() {
 }
 
 func usage() {
-	fmt.Println(`usage: navpatch [-h] <listenAddr> <baseDir> [<patchFile>]
+	fmt.Println(`usage: navpatch [-h] [-w | -b] <listenAddr> <baseDir> [<patchFile>]
+       navpatch [-w | -b] tui <baseDir> [<patchFile>]
+       navpatch [-w | -b] export -o <dir> <baseDir> [<patchFile>]
 
 Visualize a patch file through a file navigator
 
 Patch files should be formatted as understood by golang.org/x/codereview/patch.
+Git binary patches, as produced by 'git diff --binary', are understood too.
 
 Options:
   -h         : show this help message.
+  -w         : ignore all whitespace when comparing lines, like 'git diff -w'.
+  -b         : ignore changes in the amount of whitespace, like 'git diff -b'.
   listenAddr : the HTTP address in which to serve the web interface.
                ':0' serves at an arbitrary port.
+  tui        : instead of serving the web interface, show the navigator
+               full-screen in the terminal.
+  export     : instead of serving the web interface, write it as a static
+               site to the <dir> given with -o. If <dir> ends in '.html',
+               write instead a single file with the changed files only.
   baseDir    : path to the directory to which the patch is applied.
   patchFile  : path or URL to the patch file to be applied.
                If ommitted, reads from stdin.`)
@@ -100,7 +100,7 @@ func (gc gitCommandUnix) patchNavigator(repoURL, oldCommit, newCommit string, fe
 		os.RemoveAll(repoPath)
 	}
 
-	cmd := exec.Command("git", "diff", "--no-color", oldCommit, newCommit)
+	cmd := exec.Command("git", "diff", "--no-color", "--binary", "-M", "-C", oldCommit, newCommit)
 	cmd.Dir = repoPath
 	rawPatch, err := cmd.CombinedOutput()
 	if err != nil {
@@ -120,7 +120,7 @@ func (ctxt *requestContext) handleRoot() {
 		return
 	}
 
-	linksURL := ctxt.req.URL
+	linksURL := *ctxt.req.URL
 	q := linksURL.Query()
 	q.Del("path")
 	linksURL.RawQuery = q.Encode() + "&path="
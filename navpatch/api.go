@@ -0,0 +1,215 @@
+package navpatch
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aryann/difflib"
+	"golang.org/x/codereview/patch"
+)
+
+type jsonEntry struct {
+	Path    string       `json:"path"`
+	Name    string       `json:"name"`
+	IsDir   bool         `json:"isDir"`
+	Stats   *jsonStats   `json:"stats"`
+	Entries []*jsonEntry `json:"entries,omitempty"`
+	Hunks   []*jsonHunk  `json:"hunks,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type jsonStats struct {
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Added     bool   `json:"added"`
+	Removed   bool   `json:"removed"`
+	Renamed   bool   `json:"renamed"`
+	Copied    bool   `json:"copied"`
+	OldPath   string `json:"oldPath,omitempty"`
+	OldMode   int    `json:"oldMode,omitempty"`
+	NewMode   int    `json:"newMode,omitempty"`
+	Binary    bool   `json:"binary"`
+	OldSize   int    `json:"oldSize,omitempty"`
+	NewSize   int    `json:"newSize,omitempty"`
+	MovedIn   int    `json:"movedIn,omitempty"`
+	MovedOut  int    `json:"movedOut,omitempty"`
+	Generated bool   `json:"generated,omitempty"`
+	Vendored  bool   `json:"vendored,omitempty"`
+}
+
+type jsonHunk struct {
+	OldStart int         `json:"oldStart"`
+	OldLines int         `json:"oldLines"`
+	NewStart int         `json:"newStart"`
+	NewLines int         `json:"newLines"`
+	Lines    []*jsonLine `json:"lines"`
+}
+
+type jsonLine struct {
+	Kind string `json:"kind"`
+	Old  int    `json:"old,omitempty"`
+	New  int    `json:"new,omitempty"`
+	Text string `json:"text"`
+}
+
+// Line kinds in jsonLine.
+const (
+	lineContext  = "context"
+	lineAddition = "addition"
+	lineDeletion = "deletion"
+)
+
+// wantsJSON tells whether a request asks for JSON, either explicitly with
+// format=json or by accepting application/json.
+func wantsJSON(req *http.Request) bool {
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// serveJSON serves the folder or file at path as JSON. Folders list their
+// entries with their stats; changed files, their hunks, with as many context
+// lines as the request's view options tell.
+func (nav *Navigator) serveJSON(w http.ResponseWriter, req *http.Request, path string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	ret := &jsonEntry{Path: strings.Join(parts, "/")}
+	if len(parts) > 0 {
+		ret.Name = parts[len(parts)-1]
+	}
+
+	opts := viewOptionsFromRequest(req)
+	levels, err := nav.makeTplLevels(path, opts)
+	if err == errBadPath || len(levels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		ret.Error = "not found"
+		json.NewEncoder(w).Encode(ret)
+		return
+	}
+
+	level := levels[len(levels)-1]
+	ret.IsDir = level.IsDir
+	ret.Stats = makeJSONStats(opts.shownStats(nav.Changes[ret.Path]))
+
+	if err != nil && err != patch.ErrPatchFailure {
+		log.Println(path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if level.Error != nil {
+		ret.Error = level.Error.Error()
+	}
+
+	if ret.IsDir {
+		ret.Entries = []*jsonEntry{}
+		for _, e := range level.Entries {
+			entryPath := strings.TrimPrefix(level.Path+"/"+e.Name, "/")
+			ret.Entries = append(ret.Entries, &jsonEntry{
+				Path:  entryPath,
+				Name:  e.Name,
+				IsDir: e.IsDir,
+				Stats: makeJSONStats(opts.shownStats(nav.Changes[entryPath])),
+			})
+		}
+	} else if ret.Stats != nil && level.Body != "" && (level.Kind == "" || opts.ShowGenerated) {
+		ret.Hunks = makeJSONHunks(level.Body, opts.Context)
+	}
+
+	err = json.NewEncoder(w).Encode(ret)
+	if err != nil {
+		log.Println(path, err)
+	}
+}
+
+func makeJSONStats(s *DiffStats) *jsonStats {
+	if s == nil {
+		return nil
+	}
+	return &jsonStats{
+		Additions: s.Additions,
+		Deletions: s.Deletions,
+		Added:     s.Added,
+		Removed:   s.Removed,
+		Renamed:   s.Renamed,
+		Copied:    s.Copied,
+		OldPath:   s.OldPath,
+		OldMode:   s.OldMode,
+		NewMode:   s.NewMode,
+		Binary:    s.Binary,
+		OldSize:   s.OldSize,
+		NewSize:   s.NewSize,
+		MovedIn:   s.MovedIn,
+		MovedOut:  s.MovedOut,
+		Generated: s.Generated,
+		Vendored:  s.Vendored,
+	}
+}
+
+// makeJSONHunks groups the changes in a diff as rendered by applyPatch in
+// hunks with up to context unchanged lines around them. If context is
+// negative, the whole file is a single hunk.
+func makeJSONHunks(diff string, context int) []*jsonHunk {
+	records := parseDiff(diff)
+
+	keep := make([]bool, len(records))
+	for i, rec := range records {
+		if rec.Delta == difflib.Common {
+			continue
+		}
+		from, to := i-context, i+context
+		if context < 0 {
+			from, to = 0, len(records)-1
+		}
+		for j := from; j <= to; j++ {
+			if j >= 0 && j < len(records) {
+				keep[j] = true
+			}
+		}
+	}
+
+	var hunks []*jsonHunk
+	var hunk *jsonHunk
+	oldNum, newNum := 0, 0
+	for i, rec := range records {
+		line := &jsonLine{Text: rec.Payload}
+		switch rec.Delta {
+		case difflib.RightOnly:
+			newNum++
+			line.Kind, line.New = lineAddition, newNum
+		case difflib.LeftOnly:
+			oldNum++
+			line.Kind, line.Old = lineDeletion, oldNum
+		default:
+			oldNum++
+			newNum++
+			line.Kind, line.Old, line.New = lineContext, oldNum, newNum
+		}
+
+		if !keep[i] {
+			hunk = nil
+			continue
+		}
+		if hunk == nil {
+			// Starts are the first line of each side that the hunk covers.
+			hunk = &jsonHunk{OldStart: oldNum, NewStart: newNum}
+			if rec.Delta == difflib.RightOnly {
+				hunk.OldStart++
+			} else if rec.Delta == difflib.LeftOnly {
+				hunk.NewStart++
+			}
+			hunks = append(hunks, hunk)
+		}
+		if line.Kind != lineAddition {
+			hunk.OldLines++
+		}
+		if line.Kind != lineDeletion {
+			hunk.NewLines++
+		}
+		hunk.Lines = append(hunk.Lines, line)
+	}
+
+	return hunks
+}
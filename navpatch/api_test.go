@@ -0,0 +1,109 @@
+package navpatch
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestMakeJSONHunks(c *C) {
+	diff := "  a\n  b\n  c\n  d\n- e\n+ E\n  f\n  g\n  h\n  i\n+ j\n"
+
+	hunks := makeJSONHunks(diff, 1)
+	c.Assert(hunks, HasLen, 2)
+	c.Assert(*hunks[0], DeepEquals, jsonHunk{
+		OldStart: 4, OldLines: 3, NewStart: 4, NewLines: 3,
+		Lines: []*jsonLine{
+			{Kind: lineContext, Old: 4, New: 4, Text: "d"},
+			{Kind: lineDeletion, Old: 5, Text: "e"},
+			{Kind: lineAddition, New: 5, Text: "E"},
+			{Kind: lineContext, Old: 6, New: 6, Text: "f"},
+		},
+	})
+	c.Assert(*hunks[1], DeepEquals, jsonHunk{
+		OldStart: 9, OldLines: 1, NewStart: 9, NewLines: 2,
+		Lines: []*jsonLine{
+			{Kind: lineContext, Old: 9, New: 9, Text: "i"},
+			{Kind: lineAddition, New: 10, Text: "j"},
+		},
+	})
+
+	hunks = makeJSONHunks(diff, -1)
+	c.Assert(hunks, HasLen, 1)
+	c.Assert(hunks[0].OldLines, Equals, 9)
+	c.Assert(hunks[0].NewLines, Equals, 10)
+
+	// Hunks starting with an addition start after the old line before it.
+	hunks = makeJSONHunks("  a\n+ b\n", 0)
+	c.Assert(hunks, HasLen, 1)
+	c.Assert(hunks[0].OldStart, Equals, 2)
+	c.Assert(hunks[0].NewStart, Equals, 2)
+}
+
+func (s *PatchS) TestServeJSON(c *C) {
+	nav := testNavigator(c, longFileTree, longFilePatch)
+
+	var folder jsonEntry
+	w := serveTest(nav, "/", "Accept", "application/json")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &folder), IsNil)
+	c.Assert(folder.IsDir, Equals, true)
+	c.Assert(folder.Entries, HasLen, 3)
+	c.Assert(folder.Entries[2].Path, Equals, "long.txt")
+	c.Assert(*folder.Entries[2].Stats, Equals, jsonStats{Additions: 1, Deletions: 1})
+	c.Assert(folder.Entries[0].Stats, IsNil)
+
+	var file jsonEntry
+	w = serveTest(nav, "/long.txt?format=json&context=1")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &file), IsNil)
+	c.Assert(file.IsDir, Equals, false)
+	c.Assert(file.Hunks, HasLen, 1)
+	c.Assert(file.Hunks[0].OldStart, Equals, 14)
+	c.Assert(file.Hunks[0].OldLines, Equals, 3)
+	c.Assert(file.Hunks[0].Lines[1].Text, Equals, "line 15")
+
+	w = serveTest(nav, "/missing?format=json")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
+
+func (s *PatchS) TestAPIFolderIsBrowsable(c *C) {
+	nav := testNavigator(c, func() *TreeFolder {
+		tree := testTree()
+		api := NewTreeFolder("api")
+		api.Entries = []TreeEntry{NewTreeFile("api.go", func() (string, error) {
+			return "package api\n", nil
+		})}
+		tree.Entries = append(tree.Entries, api)
+		return tree
+	}, "")
+
+	w := serveTest(nav, "/api/api.go")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(w.Body.String(), Matches, `(?s)\s*<!DOCTYPE html>.*package.*api.*`)
+}
+
+func (s *PatchS) TestServeJSONEmptyFile(c *C) {
+	nav := testNavigator(c, func() *TreeFolder {
+		tree := testTree()
+		tree.Entries = append(tree.Entries, NewTreeFile("empty.txt", func() (string, error) {
+			return "", nil
+		}))
+		return tree
+	}, "diff --git a/.gitkeep b/.gitkeep\n"+
+		"new file mode 100644\n"+
+		"index 0000000..e69de29\n")
+
+	for _, path := range []string{"/.gitkeep", "/empty.txt"} {
+		var file jsonEntry
+		w := serveTest(nav, path+"?format=json")
+		c.Assert(w.Code, Equals, http.StatusOK)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &file), IsNil)
+		c.Assert(file.IsDir, Equals, false, Commentf(path))
+		c.Assert(file.Entries, IsNil, Commentf(path))
+	}
+	var file jsonEntry
+	c.Assert(json.Unmarshal(serveTest(nav, "/.gitkeep?format=json").Body.Bytes(), &file), IsNil)
+	c.Assert(file.Stats.Added, Equals, true)
+}
@@ -0,0 +1,547 @@
+package navpatch
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An apiChange is a change to the exported API of a Go package.
+type apiChange struct {
+	// Like "func F", "method T.M" or "field T.X".
+	Name string
+	// added, removed or changed.
+	Change string
+	// The declaration before and after the patch, without names of
+	// parameters and results.
+	Old, New string
+	// Whether it can break code that uses the package.
+	Breaking bool
+}
+
+// A packageAPI holds the exported API changes of a Go package.
+type packageAPI struct {
+	// Path of the package's folder, without leading slash.
+	Path    string
+	Name    string
+	Changes []apiChange
+}
+
+// Breaking counts the changes that can break code that uses the package.
+func (p packageAPI) Breaking() int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Breaking {
+			n++
+		}
+	}
+	return n
+}
+
+// An apiDecl is an exported declaration, or a member of one.
+type apiDecl struct {
+	desc string
+	// desc with type parameters named by their position, which is what's
+	// compared, so that renaming them isn't a change.
+	sig string
+	// The type a field or method belongs to, if any.
+	owner string
+	// Adding methods to an interface breaks its implementations.
+	inInterface bool
+}
+
+// apiChanges returns the exported API changes of the Go packages the patch
+// changed, by folder path. They're computed once per Navigator.
+func (nav *Navigator) apiChanges() []packageAPI {
+	nav.apiOnce.Do(func() {
+		nav.api = nav.computeAPIChanges()
+	})
+	return nav.api
+}
+
+func (nav *Navigator) computeAPIChanges() []packageAPI {
+	// Folders with changed Go files, before or after the patch.
+	dirs := map[string]bool{}
+	for path, stats := range nav.Changes {
+		if isGoSource(path) {
+			dirs[pathDir(path)] = true
+		}
+		if stats.OldPath != "" && isGoSource(stats.OldPath) {
+			dirs[pathDir(stats.OldPath)] = true
+		}
+	}
+
+	olds, news := map[string][]string{}, map[string][]string{}
+	var walk func(prefix string, folder *TreeFolder)
+	walk = func(prefix string, folder *TreeFolder) {
+		for _, entry := range folder.Entries {
+			path := prefix + entry.Name()
+			switch e := entry.(type) {
+			case *TreeFolder:
+				walk(path+"/", e)
+			case *TreeFile:
+				stats := nav.Changes[path]
+				oldPath := path
+				if stats != nil && stats.OldPath != "" {
+					oldPath = stats.OldPath
+				}
+				oldDir, newDir := pathDir(oldPath), pathDir(path)
+				// Copies didn't take anything from their source's package.
+				hasOld := isGoSource(oldPath) && dirs[oldDir] && (stats == nil || !stats.Added && !stats.Copied)
+				hasNew := isGoSource(path) && dirs[newDir] && (stats == nil || !stats.Removed)
+				if e.IsBinary() || !hasOld && !hasNew {
+					continue
+				}
+				contents, err := e.Contents()
+				if err != nil {
+					log.Println(path, err)
+					continue
+				}
+				old, new := contents, contents
+				if stats != nil {
+					old, new = splitDiff(contents)
+				}
+				if hasOld {
+					olds[oldDir] = append(olds[oldDir], old)
+				}
+				if hasNew {
+					news[newDir] = append(news[newDir], new)
+				}
+			}
+		}
+	}
+	if root, ok := nav.BaseDir.(*TreeFolder); ok {
+		walk("", root)
+	}
+
+	var ret []packageAPI
+	for dir := range dirs {
+		oldAPI, newAPI := apiSurface(olds[dir]), apiSurface(news[dir])
+		var names []string
+		for name := range oldAPI {
+			names = append(names, name)
+		}
+		for name := range newAPI {
+			if oldAPI[name] == nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			changes := diffAPI(oldAPI[name], newAPI[name])
+			if len(changes) > 0 {
+				ret = append(ret, packageAPI{Path: dir, Name: name, Changes: changes})
+			}
+		}
+	}
+	sort.Sort(packageAPIs(ret))
+	return ret
+}
+
+type packageAPIs []packageAPI
+
+func (s packageAPIs) Len() int      { return len(s) }
+func (s packageAPIs) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s packageAPIs) Less(i, j int) bool {
+	if s[i].Path != s[j].Path {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Name < s[j].Name
+}
+
+// isGoSource reports whether path is a Go file that can be part of a
+// package's API; that is, not a test.
+func isGoSource(path string) bool {
+	return strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go")
+}
+
+// pathDir returns the folder of a path without leading slash, or "" for the
+// root.
+func pathDir(path string) string {
+	if i := strings.LastIndex(path, "/"); i != -1 {
+		return path[:i]
+	}
+	return ""
+}
+
+// diffAPI compares the exported declarations of a package before and after
+// the patch. Members of added or removed types aren't listed on their own.
+func diffAPI(old, new map[string]apiDecl) []apiChange {
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var ret []apiChange
+	for _, name := range names {
+		o, inOld := old[name]
+		n, inNew := new[name]
+		switch {
+		case !inOld:
+			if _, ok := old["type "+n.owner]; n.owner != "" && !ok {
+				continue
+			}
+			ret = append(ret, apiChange{Name: name, Change: "added", New: n.desc, Breaking: n.inInterface})
+		case !inNew:
+			if _, ok := new["type "+o.owner]; o.owner != "" && !ok {
+				continue
+			}
+			ret = append(ret, apiChange{Name: name, Change: "removed", Old: o.desc, Breaking: true})
+		case o.sig != n.sig:
+			ret = append(ret, apiChange{Name: name, Change: "changed", Old: o.desc, New: n.desc, Breaking: true})
+		}
+	}
+	return ret
+}
+
+// apiSurface returns the exported declarations of the packages in some Go
+// source files, by package name. main packages, which can't be imported, and
+// files that don't parse are left out.
+func apiSurface(srcs []string) map[string]map[string]apiDecl {
+	ret := map[string]map[string]apiDecl{}
+	for _, src := range srcs {
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", src, 0)
+		if err != nil || f.Name.Name == "main" {
+			continue
+		}
+		decls := ret[f.Name.Name]
+		if decls == nil {
+			decls = map[string]apiDecl{}
+			ret[f.Name.Name] = decls
+		}
+		addAPIDecls(decls, fset, f)
+	}
+	return ret
+}
+
+func addAPIDecls(decls map[string]apiDecl, fset *token.FileSet, f *ast.File) {
+	str := func(node ast.Node) string {
+		var buf bytes.Buffer
+		printer.Fprint(&buf, fset, node)
+		return strings.Join(strings.Fields(buf.String()), " ")
+	}
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				describe := func(str func(ast.Node) string) string {
+					return "func " + d.Name.Name + funcSignature(str, d.Type)
+				}
+				decls["func "+d.Name.Name] = apiDecl{
+					desc: describe(str),
+					sig:  describe(byPosition(str, fieldNames(d.Type.TypeParams))),
+				}
+				continue
+			}
+			recvType := d.Recv.List[0].Type
+			recv := receiverName(recvType)
+			if !ast.IsExported(recv) {
+				continue
+			}
+			describe := func(str func(ast.Node) string) string {
+				return "func (" + str(recvType) + ") " + d.Name.Name + funcSignature(str, d.Type)
+			}
+			decls["method "+recv+"."+d.Name.Name] = apiDecl{
+				desc:  describe(str),
+				sig:   describe(byPosition(str, receiverTypeParams(recvType))),
+				owner: recv,
+			}
+		case *ast.GenDecl:
+			var lastType ast.Expr
+			var lastValues []ast.Expr
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						addAPIType(decls, str, s)
+					}
+				case *ast.ValueSpec:
+					// Constants without type nor values repeat the previous
+					// ones, as with iota.
+					typ, values := s.Type, s.Values
+					if d.Tok == token.CONST && typ == nil && len(values) == 0 {
+						typ, values = lastType, lastValues
+					}
+					lastType, lastValues = typ, values
+					for i, name := range s.Names {
+						if !name.IsExported() {
+							continue
+						}
+						// Without a type, the value tells it.
+						desc := d.Tok.String() + " " + name.Name
+						if typ != nil {
+							desc += " " + str(typ)
+						} else if i < len(values) {
+							desc += " = " + str(values[i])
+						}
+						decls[d.Tok.String()+" "+name.Name] = apiDecl{desc: desc, sig: desc}
+					}
+				}
+			}
+		}
+	}
+}
+
+// addAPIType adds an exported type, and its exported fields or interface
+// methods, to decls.
+func addAPIType(decls map[string]apiDecl, str func(ast.Node) string, s *ast.TypeSpec) {
+	name := s.Name.Name
+	norm := byPosition(str, fieldNames(s.TypeParams))
+	head := func(str func(ast.Node) string) string {
+		ret := "type " + name
+		if s.TypeParams != nil {
+			ret += "[" + typeParamList(str, s.TypeParams) + "]"
+		}
+		if s.Assign != 0 {
+			ret += " ="
+		}
+		return ret
+	}
+
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		decls["type "+name] = apiDecl{desc: head(str) + " struct", sig: head(norm) + " struct"}
+		for _, field := range t.Fields.List {
+			if len(field.Names) == 0 {
+				// Embedded fields are named by their type.
+				if embedded := embeddedName(field.Type); ast.IsExported(embedded) {
+					decls["field "+name+"."+embedded] = apiDecl{desc: str(field.Type), sig: norm(field.Type), owner: name}
+				}
+				continue
+			}
+			for _, n := range field.Names {
+				if n.IsExported() {
+					decls["field "+name+"."+n.Name] = apiDecl{
+						desc:  n.Name + " " + str(field.Type),
+						sig:   n.Name + " " + norm(field.Type),
+						owner: name,
+					}
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		decls["type "+name] = apiDecl{desc: head(str) + " interface", sig: head(norm) + " interface"}
+		for _, m := range t.Methods.List {
+			if len(m.Names) == 0 {
+				// Embedded interfaces and constraints are named by
+				// themselves.
+				decls["embedded "+name+"."+norm(m.Type)] = apiDecl{
+					desc:        str(m.Type),
+					sig:         norm(m.Type),
+					owner:       name,
+					inInterface: true,
+				}
+				continue
+			}
+			ft, ok := m.Type.(*ast.FuncType)
+			if !ok {
+				continue
+			}
+			for _, n := range m.Names {
+				if !n.IsExported() {
+					continue
+				}
+				decls["method "+name+"."+n.Name] = apiDecl{
+					desc:        n.Name + funcSignature(str, ft),
+					sig:         n.Name + funcSignature(norm, ft),
+					owner:       name,
+					inInterface: true,
+				}
+			}
+		}
+	default:
+		decls["type "+name] = apiDecl{desc: head(str) + " " + str(s.Type), sig: head(norm) + " " + norm(s.Type)}
+	}
+}
+
+// embeddedName returns the name of an embedded field of type expr.
+func embeddedName(expr ast.Expr) string {
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		return embeddedName(star.X)
+	}
+	return receiverName(expr)
+}
+
+// funcSignature returns the type parameters of a function, and its
+// parameters and results with their types only, so that renaming them isn't a
+// change.
+func funcSignature(str func(ast.Node) string, ft *ast.FuncType) string {
+	sig := ""
+	if ft.TypeParams != nil {
+		sig += "[" + typeParamList(str, ft.TypeParams) + "]"
+	}
+	sig += "(" + fieldTypes(str, ft.Params) + ")"
+	if ft.Results == nil || len(ft.Results.List) == 0 {
+		return sig
+	}
+	results := fieldTypes(str, ft.Results)
+	if len(ft.Results.List) == 1 && len(ft.Results.List[0].Names) <= 1 {
+		return sig + " " + results
+	}
+	return sig + " (" + results + ")"
+}
+
+func fieldTypes(str func(ast.Node) string, fields *ast.FieldList) string {
+	if fields == nil {
+		return ""
+	}
+	var types []string
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, str(field.Type))
+		}
+	}
+	return strings.Join(types, ", ")
+}
+
+// typeParamList returns a list of type parameters with their names and
+// constraints.
+func typeParamList(str func(ast.Node) string, fields *ast.FieldList) string {
+	var params []string
+	for _, field := range fields.List {
+		var names []string
+		for _, n := range field.Names {
+			names = append(names, str(n))
+		}
+		params = append(params, strings.Join(names, ", ")+" "+str(field.Type))
+	}
+	return strings.Join(params, ", ")
+}
+
+func fieldNames(fields *ast.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	var names []string
+	for _, field := range fields.List {
+		for _, n := range field.Names {
+			names = append(names, n.Name)
+		}
+	}
+	return names
+}
+
+// receiverTypeParams returns the names of the type parameters of a method's
+// receiver, like K and V in *Map[K, V].
+func receiverTypeParams(expr ast.Expr) []string {
+	var indices []ast.Expr
+	for indices == nil {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			indices = []ast.Expr{e.Index}
+		case *ast.IndexListExpr:
+			indices = e.Indices
+		default:
+			return nil
+		}
+	}
+	var names []string
+	for _, index := range indices {
+		if id, ok := index.(*ast.Ident); ok {
+			names = append(names, id.Name)
+		}
+	}
+	return names
+}
+
+// byPosition returns a printer like str that names the type parameters in
+// params by their position instead, as $1, $2 and so on, which can't clash
+// with identifiers.
+func byPosition(str func(ast.Node) string, params []string) func(ast.Node) string {
+	if len(params) == 0 {
+		return str
+	}
+	return func(node ast.Node) string {
+		var renamed []*ast.Ident
+		var names []string
+		selected := map[*ast.Ident]bool{}
+		ast.Inspect(node, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				// In pkg.T, T isn't a type parameter.
+				selected[n.Sel] = true
+			case *ast.Ident:
+				for i, p := range params {
+					if n.Name == p && !selected[n] {
+						renamed, names = append(renamed, n), append(names, n.Name)
+						n.Name = "$" + strconv.Itoa(i+1)
+						break
+					}
+				}
+			}
+			return true
+		})
+		ret := str(node)
+		for i, n := range renamed {
+			n.Name = names[i]
+		}
+		return ret
+	}
+}
+
+// apiBadge counts the exported API changes of the Go packages in a folder.
+func (nav *Navigator) apiBadge(dir string) (changes, breaking int) {
+	for _, p := range nav.apiChanges() {
+		if p.Path == dir {
+			changes += len(p.Changes)
+			breaking += p.Breaking()
+		}
+	}
+	return
+}
+
+type tplAPIDiffData struct {
+	Title    string
+	Packages []packageAPI
+	pageLinks
+}
+
+// serveAPIDiff serves the exported API changes of the Go packages the patch
+// changed.
+func (nav *Navigator) serveAPIDiff(w http.ResponseWriter, req *http.Request, path, linksPrefix, linksSuffix string) {
+	data := &tplAPIDiffData{
+		Title:     "navpatch - API changes",
+		Packages:  nav.apiChanges(),
+		pageLinks: makePageLinks(path, linksPrefix, linksSuffix, "apidiff"),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := templates.ExecuteTemplate(w, "apidiff", data)
+	if err != nil {
+		log.Println("apidiff", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,133 @@
+package navpatch
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestDiffAPI(c *C) {
+	for _, t := range []struct {
+		comment  string
+		old, new string
+		changes  []apiChange
+	}{{
+		"renamed parameters and results aren't changes",
+		"func F(a int) (err error) { return nil }",
+		"func F(b int) (e error) { return nil }",
+		nil,
+	}, {
+		"unexported declarations aren't part of the API",
+		"func f() {}\ntype t struct{}\nfunc (t) M() {}",
+		"func f(int) {}\ntype t int\nfunc (t) M(int) {}",
+		nil,
+	}, {
+		"added and removed functions",
+		"func F() {}",
+		"func G() {}",
+		[]apiChange{
+			{Name: "func F", Change: "removed", Old: "func F()", Breaking: true},
+			{Name: "func G", Change: "added", New: "func G()"},
+		},
+	}, {
+		"methods added to interfaces break their implementations",
+		"type I interface{ M() }",
+		"type I interface {\n\tM()\n\tN(int) error\n}",
+		[]apiChange{
+			{Name: "method I.N", Change: "added", New: "N(int) error", Breaking: true},
+		},
+	}, {
+		"unexported interface methods aren't part of the API",
+		"type I interface{ M() }",
+		"type I interface {\n\tM()\n\tn(int) error\n}",
+		nil,
+	}, {
+		"untyped variables and constants are told by their values",
+		"var X = strings.NewReader(\"\")\nconst C = 1\nconst (\n\tA = iota\n\tB\n)",
+		"var X = bytes.NewReader(nil)\nconst C = 1\nconst (\n\tA = iota\n\tB\n)",
+		[]apiChange{
+			{Name: "var X", Change: "changed", Old: "var X = strings.NewReader(\"\")", New: "var X = bytes.NewReader(nil)", Breaking: true},
+		},
+	}, {
+		"fields added to structs don't break anything",
+		"type S struct{ A int }",
+		"type S struct {\n\tA int\n\tB, c string\n}",
+		[]apiChange{
+			{Name: "field S.B", Change: "added", New: "B string"},
+		},
+	}, {
+		"members of added types aren't listed on their own",
+		"",
+		"type S struct{ A int }\nfunc (S) M() {}",
+		[]apiChange{
+			{Name: "type S", Change: "added", New: "type S struct"},
+		},
+	}, {
+		"value receivers changed to pointer receivers",
+		"type T struct{}\nfunc (t T) M() {}",
+		"type T struct{}\nfunc (t *T) M() {}",
+		[]apiChange{
+			{Name: "method T.M", Change: "changed", Old: "func (T) M()", New: "func (*T) M()", Breaking: true},
+		},
+	}, {
+		"iota constants repeat the type of the previous ones",
+		"type C int\nconst (\n\tA C = iota\n\tB\n)",
+		"type C int\nconst (\n\tA C = iota\n\tB\n\tD\n)",
+		[]apiChange{
+			{Name: "const D", Change: "added", New: "const D C"},
+		},
+	}, {
+		"iota constants changing their type",
+		"type C int\nconst (\n\tA C = iota\n\tB\n)",
+		"type C int\nconst (\n\tA int = iota\n\tB\n)",
+		[]apiChange{
+			{Name: "const A", Change: "changed", Old: "const A C", New: "const A int", Breaking: true},
+			{Name: "const B", Change: "changed", Old: "const B C", New: "const B int", Breaking: true},
+		},
+	}, {
+		"renamed type parameters aren't changes",
+		"func H[T any](x T) T { return x }\ntype G[K comparable] struct{ X K }\nfunc (g *G[K]) N(k K) K { return k }",
+		"func H[U any](x U) U { return x }\ntype G[V comparable] struct{ X V }\nfunc (g *G[V]) N(k V) V { return k }",
+		nil,
+	}, {
+		"changed constraints",
+		"func H[T any](x T) T { return x }",
+		"func H[T comparable](x T) T { return x }",
+		[]apiChange{
+			{Name: "func H", Change: "changed", Old: "func H[T any](T) T", New: "func H[T comparable](T) T", Breaking: true},
+		},
+	}, {
+		"swapped type parameters",
+		"func H[K, V any](k K, v V) {}",
+		"func H[V, K any](k K, v V) {}",
+		[]apiChange{
+			{Name: "func H", Change: "changed", Old: "func H[K, V any](K, V)", New: "func H[V, K any](K, V)", Breaking: true},
+		},
+	}, {
+		"type parameters don't hide qualified names",
+		"func H[T any](x T, y other.T) {}",
+		"func H[U any](x U, y other.U) {}",
+		[]apiChange{
+			{Name: "func H", Change: "changed", Old: "func H[T any](T, other.T)", New: "func H[U any](U, other.U)", Breaking: true},
+		},
+	}} {
+		old := apiSurface([]string{"package p\n" + t.old})["p"]
+		new := apiSurface([]string{"package p\n" + t.new})["p"]
+		c.Assert(diffAPI(old, new), DeepEquals, t.changes, Commentf(t.comment))
+	}
+}
+
+func (s *PatchS) TestAPISurface(c *C) {
+	c.Assert(apiSurface([]string{"package main\nfunc F() {}"}), HasLen, 0)
+	c.Assert(apiSurface([]string{"package p\nfunc {"}), HasLen, 0)
+
+	api := apiSurface([]string{
+		"package p\nfunc F() {}",
+		"package p\ntype T struct{ io.Reader }",
+		"package p_test\nfunc G() {}",
+	})
+	c.Assert(api, HasLen, 2)
+	c.Assert(api["p"], DeepEquals, map[string]apiDecl{
+		"func F":         {desc: "func F()", sig: "func F()"},
+		"type T":         {desc: "type T struct", sig: "type T struct"},
+		"field T.Reader": {desc: "io.Reader", sig: "io.Reader", owner: "T"},
+	})
+}
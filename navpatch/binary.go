@@ -0,0 +1,338 @@
+package navpatch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/codereview/patch"
+)
+
+// binaryDiff is a patch.Diff for a Git binary patch, as produced by
+// git diff --binary. Either hunk may be nil if the patch didn't include the
+// data, as with plain git diff's "Binary files a/x and b/x differ".
+type binaryDiff struct {
+	forward *binaryHunk
+	reverse *binaryHunk
+}
+
+type binaryHunk struct {
+	delta bool
+	size  int
+	data  []byte
+}
+
+var errNoBinaryData = errors.New("binary contents not included in the patch")
+
+func (d *binaryDiff) Apply(old []byte) ([]byte, error) {
+	if d.forward == nil {
+		return nil, errNoBinaryData
+	}
+	if !d.forward.delta {
+		return d.forward.data, nil
+	}
+	return applyGitDelta(old, d.forward.data)
+}
+
+// sizes returns the size of the file before and after the patch, or -1 if
+// the patch doesn't tell.
+func (d *binaryDiff) sizes() (oldSize, newSize int) {
+	oldSize, newSize = -1, -1
+	if h := d.forward; h != nil {
+		if h.delta {
+			oldSize, newSize = deltaSizes(h.data)
+		} else {
+			newSize = h.size
+		}
+	}
+	if h := d.reverse; h != nil {
+		if h.delta {
+			_, oldSize = deltaSizes(h.data)
+		} else {
+			oldSize = h.size
+		}
+	}
+	return
+}
+
+// ParsePatch parses a patch like patch.Parse does, but also understands Git
+// binary patches, both literal and delta, which end up as the Diff of their
+// patch.File.
+func ParsePatch(rawPatch []byte) (*patch.Set, error) {
+	textPatch, binaries, err := extractBinaryDiffs(rawPatch)
+	if err != nil {
+		return nil, err
+	}
+
+	patchSet, err := patch.Parse(textPatch)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pf := range patchSet.File {
+		if d, ok := binaries[pf.Dst]; ok {
+			pf.Diff = d
+		} else if d, ok := binaries[pf.Src]; ok {
+			pf.Diff = d
+		}
+	}
+
+	return patchSet, nil
+}
+
+// extractBinaryDiffs removes binary patches from rawPatch, returning them
+// keyed by the path of the file they apply to.
+func extractBinaryDiffs(rawPatch []byte) ([]byte, map[string]*binaryDiff, error) {
+	binaries := map[string]*binaryDiff{}
+
+	var ret bytes.Buffer
+	var path string
+	var binary []string
+	inBinary := false
+
+	flush := func() error {
+		if !inBinary {
+			return nil
+		}
+		d, err := parseGitBinary(binary)
+		if err != nil {
+			return fmt.Errorf("parsing binary patch for %s: %s", path, err)
+		}
+		binaries[path] = d
+		inBinary = false
+		binary = nil
+		return nil
+	}
+
+	for _, line := range strings.SplitAfter(string(rawPatch), "\n") {
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(trimmed, "diff --git "):
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+			if i := strings.LastIndex(trimmed, " b/"); i != -1 {
+				path = trimmed[i+len(" b/"):]
+			}
+		case inBinary:
+			binary = append(binary, trimmed)
+			continue
+		case strings.HasPrefix(trimmed, "rename to "):
+			path = strings.TrimPrefix(trimmed, "rename to ")
+		case strings.HasPrefix(trimmed, "copy to "):
+			path = strings.TrimPrefix(trimmed, "copy to ")
+		case trimmed == "GIT binary patch":
+			inBinary = true
+			continue
+		case strings.HasPrefix(trimmed, "Binary files ") && strings.HasSuffix(trimmed, " differ"):
+			binaries[path] = &binaryDiff{}
+			continue
+		}
+
+		ret.WriteString(line)
+	}
+
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	return ret.Bytes(), binaries, nil
+}
+
+// parseGitBinary parses the lines following a "GIT binary patch" header: a
+// forward hunk and, optionally, a reverse one, separated by an empty line.
+func parseGitBinary(lines []string) (*binaryDiff, error) {
+	var hunks []*binaryHunk
+
+	for len(lines) > 0 {
+		if lines[0] == "" {
+			lines = lines[1:]
+			continue
+		}
+
+		h := &binaryHunk{}
+		var sizeStr string
+		if strings.HasPrefix(lines[0], "literal ") {
+			sizeStr = strings.TrimPrefix(lines[0], "literal ")
+		} else if strings.HasPrefix(lines[0], "delta ") {
+			h.delta = true
+			sizeStr = strings.TrimPrefix(lines[0], "delta ")
+		} else {
+			return nil, fmt.Errorf("unexpected line: %q", lines[0])
+		}
+		var err error
+		h.size, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad hunk size: %q", lines[0])
+		}
+		lines = lines[1:]
+
+		var compressed []byte
+		for len(lines) > 0 && lines[0] != "" {
+			bs, err := decodeBase85Line(lines[0])
+			if err != nil {
+				return nil, err
+			}
+			compressed = append(compressed, bs...)
+			lines = lines[1:]
+		}
+
+		zr, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("inflating hunk: %s", err)
+		}
+		h.data, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("inflating hunk: %s", err)
+		}
+		if len(h.data) != h.size {
+			return nil, fmt.Errorf("hunk size mismatch: expected %d, got %d", h.size, len(h.data))
+		}
+
+		hunks = append(hunks, h)
+	}
+
+	d := &binaryDiff{}
+	if len(hunks) > 0 {
+		d.forward = hunks[0]
+	}
+	if len(hunks) > 1 {
+		d.reverse = hunks[1]
+	}
+	return d, nil
+}
+
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// decodeBase85Line decodes a line of Git's base85 encoding, whose first
+// character tells the length of the decoded data: A-Z for 1-26 bytes, a-z
+// for 27-52 bytes.
+func decodeBase85Line(line string) ([]byte, error) {
+	if len(line) < 1 {
+		return nil, errors.New("empty base85 line")
+	}
+
+	var n int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		n = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		n = int(c-'a') + 27
+	default:
+		return nil, fmt.Errorf("bad base85 line length: %q", line)
+	}
+
+	enc := line[1:]
+	if len(enc)%5 != 0 || len(enc)/5*4 < n {
+		return nil, fmt.Errorf("bad base85 line: %q", line)
+	}
+
+	ret := make([]byte, 0, len(enc)/5*4)
+	for i := 0; i < len(enc); i += 5 {
+		var v uint32
+		for _, c := range []byte(enc[i : i+5]) {
+			d := strings.IndexByte(base85Alphabet, c)
+			if d == -1 {
+				return nil, fmt.Errorf("bad base85 character %q", c)
+			}
+			v = v*85 + uint32(d)
+		}
+		ret = append(ret, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return ret[:n], nil
+}
+
+// deltaSizes returns the source and target sizes declared in a Git delta's
+// header, or -1 if it's malformed.
+func deltaSizes(delta []byte) (srcSize, dstSize int) {
+	srcSize, delta = deltaHeaderSize(delta)
+	if srcSize == -1 {
+		return -1, -1
+	}
+	dstSize, _ = deltaHeaderSize(delta)
+	return srcSize, dstSize
+}
+
+func deltaHeaderSize(delta []byte) (int, []byte) {
+	size, shift := 0, uint(0)
+	for i, b := range delta {
+		size |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, delta[i+1:]
+		}
+	}
+	return -1, nil
+}
+
+var errBadDelta = errors.New("malformed binary delta")
+
+// applyGitDelta applies a delta in Git's packfile delta format to src.
+func applyGitDelta(src, delta []byte) ([]byte, error) {
+	srcSize, delta := deltaHeaderSize(delta)
+	if srcSize != len(src) {
+		return nil, patch.ErrPatchFailure
+	}
+	dstSize, delta := deltaHeaderSize(delta)
+	if dstSize == -1 {
+		return nil, errBadDelta
+	}
+
+	dst := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case cmd&0x80 != 0:
+			// Copy from src. The low 4 bits tell which offset bytes follow,
+			// the next 3 which size bytes do.
+			var offset, size int
+			for i := uint(0); i < 7; i++ {
+				if cmd&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errBadDelta
+				}
+				if i < 4 {
+					offset |= int(delta[0]) << (8 * i)
+				} else {
+					size |= int(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > len(src) {
+				return nil, errBadDelta
+			}
+			dst = append(dst, src[offset:offset+size]...)
+		case cmd != 0:
+			// Insert the next cmd bytes.
+			if int(cmd) > len(delta) {
+				return nil, errBadDelta
+			}
+			dst = append(dst, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, errBadDelta
+		}
+	}
+
+	if len(dst) != dstSize {
+		return nil, errBadDelta
+	}
+
+	return dst, nil
+}
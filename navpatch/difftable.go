@@ -0,0 +1,289 @@
+package navpatch
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"strconv"
+
+	"github.com/aryann/difflib"
+)
+
+// Default number of unchanged lines shown around each change.
+const defaultContext = 3
+
+// Unchanged lines are only collapsed if there are more than these.
+const minCollapsed = 3
+
+// How many lines each expand control of a collapsed region reveals.
+const expandStep = 20
+
+// colorify renders a diff of the file called name as a table with the old
+// and new line numbers of each line, its contents highlighted by the file's
+// language. Each number links to itself as #L<old> or #R<new>. Lines in
+// moves are marked as moved, the first one of each with a link to the other
+// end. If split, the old contents go on the left and the new ones on the
+// right, with deleted and added lines of a change paired in order. If context
+// isn't negative, unchanged lines farther than that from any change are
+// collapsed; if no line changed, as in renames, mode changes and changes
+// that the whitespace mode ignores, none are.
+func colorify(diff string, name string, moves []tplMove, split bool, context int) template.HTML {
+	t := newDiffTable(diff, name, moves, split)
+	if !t.hasChanges() {
+		context = -1
+	}
+
+	var ret bytes.Buffer
+	if split {
+		ret.WriteString(`<table class="diff split"><tbody>`)
+	} else {
+		ret.WriteString(`<table class="diff"><tbody>`)
+	}
+
+	rows := t.rows()
+	for i := 0; i < len(rows); {
+		if !t.isCommon(rows[i]) {
+			t.writeRow(&ret, rows[i])
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(rows) && t.isCommon(rows[end]) {
+			end++
+		}
+
+		// Keep context lines after the previous change and before the next.
+		from, to := end, end
+		if context >= 0 {
+			from, to = i, end
+			if from > 0 {
+				from += context
+			}
+			if to < len(rows) {
+				to -= context
+			}
+			if to-from <= minCollapsed {
+				from, to = end, end
+			}
+		}
+
+		for ; i < from; i++ {
+			t.writeRow(&ret, rows[i])
+		}
+		if from < to {
+			t.writeGap(&ret, rows[from].left, rows[to-1].left+1)
+		}
+		for i = to; i < end; i++ {
+			t.writeRow(&ret, rows[i])
+		}
+	}
+
+	ret.WriteString(`</tbody></table>`)
+	return template.HTML(ret.String())
+}
+
+// colorifyLines renders the table rows for the unchanged lines of a diff
+// from record from to record to, as hidden by a collapsed region. Those are
+// never moved.
+func colorifyLines(diff string, name string, split bool, from, to int) (template.HTML, error) {
+	t := newDiffTable(diff, name, nil, split)
+	if from < 0 || to > len(t.records) || from > to {
+		return "", fmt.Errorf("lines %d-%d out of range", from, to)
+	}
+
+	var ret bytes.Buffer
+	for i := from; i < to; i++ {
+		t.writeRow(&ret, diffRow{i, i})
+	}
+	return template.HTML(ret.String()), nil
+}
+
+type diffTable struct {
+	records []difflib.DiffRecord
+	lines   []string
+	split   bool
+	// Old and new line numbers of each record; 0 if it's not on that side.
+	oldNums []int
+	newNums []int
+	// Whether each record is in a moved block, and links to the other end
+	// for the first records of blocks.
+	moved      []bool
+	movedLinks []string
+}
+
+// A diffRow holds the indexes of the records shown on the left and right
+// sides of a row; -1 for none. In unified tables, they are the same.
+type diffRow struct {
+	left, right int
+}
+
+func newDiffTable(diff string, name string, moves []tplMove, split bool) *diffTable {
+	records := parseDiff(diff)
+	t := &diffTable{
+		records:    records,
+		lines:      diffLinesHTML(records, name),
+		split:      split,
+		oldNums:    make([]int, len(records)),
+		newNums:    make([]int, len(records)),
+		moved:      make([]bool, len(records)),
+		movedLinks: make([]string, len(records)),
+	}
+
+	oldNum, newNum := 0, 0
+	for i, rec := range records {
+		if rec.Delta != difflib.RightOnly {
+			oldNum++
+			t.oldNums[i] = oldNum
+		}
+		if rec.Delta != difflib.LeftOnly {
+			newNum++
+			t.newNums[i] = newNum
+		}
+	}
+
+	for _, m := range moves {
+		for i, rec := range records {
+			num, want := t.newNums[i], difflib.RightOnly
+			if m.Out {
+				num, want = t.oldNums[i], difflib.LeftOnly
+			}
+			if rec.Delta != want || num < m.Line || num >= m.Line+m.Count {
+				continue
+			}
+			t.moved[i] = true
+			if num == m.Line {
+				verb := "from"
+				if m.Out {
+					verb = "to"
+				}
+				t.movedLinks[i] = fmt.Sprintf(`<a class="moved-link" href="%s">moved %s %s:%d</a>`,
+					html.EscapeString(m.Link), verb, html.EscapeString(m.OtherPath), m.OtherLine)
+			}
+		}
+	}
+
+	return t
+}
+
+// hasChanges reports whether any line was added or deleted.
+func (t *diffTable) hasChanges() bool {
+	for _, rec := range t.records {
+		if rec.Delta != difflib.Common {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *diffTable) rows() []diffRow {
+	var rows []diffRow
+
+	for i := 0; i < len(t.records); {
+		if !t.split || t.records[i].Delta == difflib.Common {
+			rows = append(rows, diffRow{i, i})
+			i++
+			continue
+		}
+
+		var dels, adds []int
+		for ; i < len(t.records) && t.records[i].Delta != difflib.Common; i++ {
+			if t.records[i].Delta == difflib.LeftOnly {
+				dels = append(dels, i)
+			} else {
+				adds = append(adds, i)
+			}
+		}
+		for j := 0; j < len(dels) || j < len(adds); j++ {
+			row := diffRow{-1, -1}
+			if j < len(dels) {
+				row.left = dels[j]
+			}
+			if j < len(adds) {
+				row.right = adds[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+func (t *diffTable) isCommon(row diffRow) bool {
+	return row.left != -1 && t.records[row.left].Delta == difflib.Common
+}
+
+func (t *diffTable) writeRow(w *bytes.Buffer, row diffRow) {
+	if !t.split {
+		i := row.left
+		class := t.class(i)
+		fmt.Fprintf(w, `<tr class="%s">`, class)
+		w.WriteString(lineNumCell("L", t.oldNums[i]))
+		w.WriteString(lineNumCell("R", t.newNums[i]))
+		fmt.Fprintf(w, `<td class="line-content %s">%s%s</td>`, class, t.movedLinks[i], t.lines[i])
+		w.WriteString("</tr>")
+		return
+	}
+
+	w.WriteString(`<tr>`)
+	for _, side := range []struct {
+		name string
+		i    int
+		nums []int
+	}{{"L", row.left, t.oldNums}, {"R", row.right, t.newNums}} {
+		if side.i == -1 {
+			w.WriteString(lineNumCell(side.name, 0))
+			w.WriteString(`<td class="line-content empty"></td>`)
+			continue
+		}
+		w.WriteString(lineNumCell(side.name, side.nums[side.i]))
+		fmt.Fprintf(w, `<td class="line-content %s">%s%s</td>`, t.class(side.i), t.movedLinks[side.i], t.lines[side.i])
+	}
+	w.WriteString(`</tr>`)
+}
+
+// writeGap writes a row standing for the unchanged records from from to to,
+// with controls to fetch them.
+func (t *diffTable) writeGap(w *bytes.Buffer, from, to int) {
+	cols := 3
+	if t.split {
+		cols = 4
+	}
+	fmt.Fprintf(w, `<tr class="gap" data-from="%d" data-to="%d" data-old="%d" data-new="%d">`,
+		from, to, t.oldNums[from], t.newNums[from])
+	fmt.Fprintf(w, `<td colspan="%d">`, cols)
+	fmt.Fprintf(w, `<a href="#" class="expand-down" title="Show %d more lines">↓</a> `, expandStep)
+	fmt.Fprintf(w, `<a href="#" class="expand-all"><span class="gap-count">%d</span> unchanged lines</a> `, to-from)
+	fmt.Fprintf(w, `<a href="#" class="expand-up" title="Show %d more lines">↑</a>`, expandStep)
+	w.WriteString(`</td></tr>`)
+}
+
+// class returns the classes of the row or cell of a record.
+func (t *diffTable) class(i int) string {
+	class := deltaClass(t.records[i].Delta)
+	if t.moved[i] {
+		class += " moved"
+	}
+	return class
+}
+
+func deltaClass(d difflib.DeltaType) string {
+	switch d {
+	case difflib.RightOnly:
+		return "addition"
+	case difflib.LeftOnly:
+		return "deletion"
+	}
+	return ""
+}
+
+// lineNumCell renders a line number gutter cell anchored at #<side><num>,
+// or an empty one if num is 0.
+func lineNumCell(side string, num int) string {
+	if num == 0 {
+		return `<td class="line-num"></td>`
+	}
+	anchor := side + strconv.Itoa(num)
+	return fmt.Sprintf(`<td class="line-num" id="%s"><a href="#%s">%d</a></td>`, anchor, anchor, num)
+}
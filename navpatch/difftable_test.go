@@ -0,0 +1,111 @@
+package navpatch
+
+import (
+	"net/http"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// longFileTree is testTree with a 30-line long.txt.
+func longFileTree() *TreeFolder {
+	tree := testTree()
+	tree.Entries = append(tree.Entries, NewTreeFile("long.txt", func() (string, error) {
+		return numberedLines(30), nil
+	}))
+	return tree
+}
+
+// A patch that changes line 15 of long.txt.
+const longFilePatch = `diff --git a/long.txt b/long.txt
+index 1111111..2222222 100644
+--- a/long.txt
++++ b/long.txt
+@@ -12,7 +12,7 @@
+ line 12
+ line 13
+ line 14
+-line 15
++line fifteen
+ line 16
+ line 17
+ line 18
+`
+
+func (s *PatchS) TestColorifyCollapses(c *C) {
+	tree := longFileTree()
+	set, err := ParsePatch([]byte(longFilePatch))
+	c.Assert(err, IsNil)
+	ApplyChangesToTree(set, tree)
+	diff, err := tree.Entries[2].(*TreeFile).Contents()
+	c.Assert(err, IsNil)
+
+	table := string(colorify(diff, "long.txt", nil, false, 3))
+	c.Assert(strings.Count(table, `class="gap"`), Equals, 2)
+	c.Assert(table, Matches, `(?s).*<tr class="gap" data-from="0" data-to="11" data-old="1" data-new="1">.*`)
+	c.Assert(table, Matches, `(?s).*<tr class="gap" data-from="19" data-to="31" data-old="19" data-new="19">.*`)
+	c.Assert(table, Matches, `(?s).*id="L12".*id="L18".*`)
+
+	table = string(colorify(diff, "long.txt", nil, false, -1))
+	c.Assert(table, Not(Matches), `(?s).*class="gap".*`)
+	c.Assert(table, Matches, `(?s).*id="L30".*id="R30".*`)
+	c.Assert(table, Not(Matches), `(?s).*id="[LR]31".*`)
+}
+
+func (s *PatchS) TestColorifyUnchangedFile(c *C) {
+	nav := testNavigator(c, longFileTree, "")
+	w := serveTest(nav, "/long.txt")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	body := w.Body.String()
+	c.Assert(body, Not(Matches), `(?s).*class="gap".*`)
+	c.Assert(body, Matches, `(?s).*id="R1".*line 1.*id="R30".*line 30.*`)
+	c.Assert(body, Not(Matches), `(?s).*id="R31".*`)
+}
+
+func (s *PatchS) TestColorifyNoLineChanges(c *C) {
+	for _, t := range []struct {
+		raw, url, banner string
+	}{
+		{"diff --git a/long.txt b/moved.txt\n" +
+			"similarity index 100%\n" +
+			"rename from long.txt\n" +
+			"rename to moved.txt\n", "/moved.txt", "Renamed from long.txt"},
+		{"diff --git a/long.txt b/long.txt\n" +
+			"old mode 100644\n" +
+			"new mode 100755\n", "/long.txt", "Mode changed from 100644 to 100755"},
+		{"diff --git a/long.txt b/long.txt\n" +
+			"index 1111111..2222222 100644\n" +
+			"--- a/long.txt\n" +
+			"+++ b/long.txt\n" +
+			"@@ -14,3 +14,3 @@\n" +
+			" line 14\n" +
+			"-line 15\n" +
+			"+line  15\n" +
+			" line 16\n", "/long.txt?w=1", "No line changes"},
+	} {
+		nav := testNavigator(c, longFileTree, t.raw)
+		body := serveTest(nav, t.url).Body.String()
+		c.Assert(body, Matches, `(?s).*<div class="banner">`+t.banner+`</div>.*`, Commentf(t.url))
+		c.Assert(body, Not(Matches), `(?s).*class="gap".*`, Commentf(t.url))
+		c.Assert(body, Matches, `(?s).*id="R1".*line 1.*id="R30".*line 30.*`, Commentf(t.url))
+	}
+}
+
+func (s *PatchS) TestServeLines(c *C) {
+	nav := testNavigator(c, longFileTree, longFilePatch)
+
+	w := serveTest(nav, "/long.txt")
+	c.Assert(w.Body.String(), Matches, `(?s).*data-from="0" data-to="11".*`)
+
+	w = serveTest(nav, "/long.txt?lines=0-11")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	rows := w.Body.String()
+	c.Assert(strings.Count(rows, "<tr"), Equals, 11)
+	c.Assert(rows, Matches, `(?s)<tr class=""><td class="line-num" id="L1">.*line 1.*id="R11".*line 11.*`)
+
+	w = serveTest(nav, "/long.txt?lines=19-40")
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+
+	w = serveTest(nav, "/missing.txt?lines=0-1")
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
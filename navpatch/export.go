@@ -0,0 +1,165 @@
+package navpatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/codereview/patch"
+)
+
+// Export writes the navigator to dir as a static site: one page per folder
+// and file, as served by HandleRoot, at <path>/index.html. Links between
+// pages are relative, so the site can be served by any file server or opened
+// from disk.
+//
+// Since there's no server behind, files are shown whole instead of with
+// collapsed unchanged lines, and only in the unified view.
+func (nav *Navigator) Export(dir string) error {
+	e := &exporter{
+		nav:      nav,
+		dir:      dir,
+		pageName: exportPageName(nav.BaseDir),
+	}
+	return e.export(nil, nav.BaseDir)
+}
+
+type exporter struct {
+	nav      *Navigator
+	dir      string
+	pageName string
+}
+
+func (e *exporter) export(parts []string, entry TreeEntry) error {
+	err := e.writePage(parts)
+	if err != nil {
+		return err
+	}
+
+	if folder, ok := entry.(*TreeFolder); ok {
+		for _, child := range folder.Entries {
+			err = e.export(append(parts[:len(parts):len(parts)], child.Name()), child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *exporter) writePage(parts []string) error {
+	path := "/" + strings.Join(parts, "/")
+
+	levels, err := e.nav.makeTplLevels(path, viewOptions{Context: -1})
+	if err != nil && err != patch.ErrPatchFailure {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	// Pages are at <path>/<pageName>, so links go up to the root first.
+	linksPrefix := "."
+	if len(parts) > 0 {
+		linksPrefix = strings.TrimSuffix(strings.Repeat("../", len(parts)), "/")
+	}
+	linksSuffix := "/" + e.pageName
+
+	data := e.nav.makeTplFullData(path, levels, linksPrefix, linksSuffix, viewOptions{Context: -1})
+	data.TreeData.Static = true
+	if len(parts) <= 1 {
+		data.ParentLink = linksPrefix + linksSuffix
+	}
+
+	var buf bytes.Buffer
+	err = templates.ExecuteTemplate(&buf, "full", data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	pageDir := filepath.Join(e.dir, filepath.FromSlash(strings.Join(parts, "/")))
+	err = os.MkdirAll(pageDir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(pageDir, e.pageName), buf.Bytes(), 0644)
+}
+
+// ExportBundle writes the navigator to w as a single HTML file, which works
+// when opened from disk or attached to an email. Pages are picked by a small
+// router in the file from the URL fragment. Only folders with changes and
+// changed files are included; the rest are listed, but open as a placeholder.
+func (nav *Navigator) ExportBundle(w io.Writer) error {
+	pages := map[string]string{}
+
+	var walk func(parts []string, entry TreeEntry) error
+	walk = func(parts []string, entry TreeEntry) error {
+		path := strings.Join(parts, "/")
+		if len(parts) > 0 && nav.Changes[path] == nil {
+			return nil
+		}
+
+		levels, err := nav.makeTplLevels("/"+path, viewOptions{Context: -1})
+		if err != nil && err != patch.ErrPatchFailure {
+			return fmt.Errorf("/%s: %s", path, err)
+		}
+		var buf bytes.Buffer
+		err = templates.ExecuteTemplate(&buf, "tree", tplTreeData{
+			Levels:      levels,
+			Nav:         nav,
+			LinksPrefix: "#",
+			Opts:        viewOptions{Context: -1},
+			Static:      true,
+		})
+		if err != nil {
+			return fmt.Errorf("/%s: %s", path, err)
+		}
+		pages[path] = buf.String()
+
+		if folder, ok := entry.(*TreeFolder); ok {
+			for _, child := range folder.Entries {
+				err = walk(append(parts[:len(parts):len(parts)], child.Name()), child)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err := walk(nil, nav.BaseDir)
+	if err != nil {
+		return err
+	}
+
+	data := nav.makeTplFullData("/", nil, "#", "", viewOptions{Context: -1})
+	data.TreeData.Static = true
+	return templates.ExecuteTemplate(w, "bundle", &tplBundleData{
+		tplFullData: data,
+		Pages:       pages,
+	})
+}
+
+// exportPageName returns the name of the page file written in each path's
+// folder: index.html, unless some entry in the tree is already named like
+// that, in which case it's prefixed with underscores until it isn't.
+func exportPageName(root TreeEntry) string {
+	names := map[string]bool{}
+	var walk func(entry TreeEntry)
+	walk = func(entry TreeEntry) {
+		names[entry.Name()] = true
+		if folder, ok := entry.(*TreeFolder); ok {
+			for _, child := range folder.Entries {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	name := "index.html"
+	for names[name] {
+		name = "_" + name
+	}
+	return name
+}
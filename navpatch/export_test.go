@@ -0,0 +1,49 @@
+package navpatch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestExportShowsWholeFiles(c *C) {
+	nav := testNavigator(c, longFileTree, longFilePatch)
+	dir := c.MkDir()
+	c.Assert(nav.Export(dir), IsNil)
+
+	for _, path := range []string{"long.txt", "README"} {
+		page, err := ioutil.ReadFile(filepath.Join(dir, path, "index.html"))
+		c.Assert(err, IsNil)
+		c.Assert(string(page), Not(Matches), `(?s).*data-from=.*`)
+	}
+
+	page, err := ioutil.ReadFile(filepath.Join(dir, "long.txt", "index.html"))
+	c.Assert(err, IsNil)
+	c.Assert(string(page), Matches, `(?s).*id="R1".*line 1.*fifteen.*id="R30".*line 30.*`)
+
+	page, err = ioutil.ReadFile(filepath.Join(dir, "README", "index.html"))
+	c.Assert(err, IsNil)
+	c.Assert(string(page), Matches, `(?s).*id="R1".*hello.*`)
+}
+
+func (s *PatchS) TestExportBundleShowsWholeFiles(c *C) {
+	nav := testNavigator(c, longFileTree, longFilePatch)
+	var buf bytes.Buffer
+	c.Assert(nav.ExportBundle(&buf), IsNil)
+
+	bundle := buf.String()
+	c.Assert(bundle, Not(Matches), `(?s).*data-from=.*`)
+	c.Assert(bundle, Matches, `(?s).*line 1\b.*fifteen.*line 30.*`)
+}
+
+func (s *PatchS) TestExportOutlineLinks(c *C) {
+	nav := testNavigator(c, testTree, searchPatch)
+	dir := c.MkDir()
+	c.Assert(nav.Export(dir), IsNil)
+
+	page, err := ioutil.ReadFile(filepath.Join(dir, "foo", "index.html"))
+	c.Assert(err, IsNil)
+	c.Assert(string(page), Matches, `(?s).*<li><a href="[^"]*a\.go[^"#]*#R3" title="a\.go">.*`)
+}
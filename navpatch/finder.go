@@ -0,0 +1,132 @@
+package navpatch
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The file finder returns at most this many paths.
+const maxFindResults = 20
+
+// Score bonuses for fuzzy matches.
+const (
+	findConsecutiveBonus = 5
+	findSegmentBonus     = 8
+	findBaseNameBonus    = 3
+	findChangedBonus     = 20
+)
+
+type findResult struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+	score   int
+}
+
+type findResults []findResult
+
+func (s findResults) Len() int      { return len(s) }
+func (s findResults) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s findResults) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	return s[i].Path < s[j].Path
+}
+
+// serveFind serves, as JSON, the paths of the files in the tree that best
+// fuzzy-match query, for the file finder.
+func (nav *Navigator) serveFind(w http.ResponseWriter, query string) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(nav.findFiles(query, maxFindResults))
+	if err != nil {
+		log.Println("find", err)
+	}
+}
+
+// findFiles returns up to limit paths of files in the tree that contain the
+// characters of query in order, best matches first. Files changed by the
+// patch rank higher.
+func (nav *Navigator) findFiles(query string, limit int) []findResult {
+	results := findResults{}
+
+	var walk func(prefix string, folder *TreeFolder)
+	walk = func(prefix string, folder *TreeFolder) {
+		for _, entry := range folder.Entries {
+			path := prefix + entry.Name()
+			switch e := entry.(type) {
+			case *TreeFolder:
+				walk(path+"/", e)
+			case *TreeFile:
+				score, ok := fuzzyScore(query, path)
+				if !ok {
+					continue
+				}
+				_, changed := nav.Changes[path]
+				if changed {
+					score += findChangedBonus
+				}
+				results = append(results, findResult{Path: path, Changed: changed, score: score})
+			}
+		}
+	}
+	if root, ok := nav.BaseDir.(*TreeFolder); ok {
+		walk("", root)
+	}
+
+	sort.Sort(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// fuzzyScore reports whether path contains the characters of query in order,
+// ignoring case, and how well: characters matched one after the other, at
+// the start of a path segment or word, or in the base name score more.
+func fuzzyScore(query, path string) (score int, ok bool) {
+	query = strings.ToLower(query)
+	lowerPath := strings.ToLower(path)
+	// Lowercasing may change byte lengths, so offsets are all in lowerPath.
+	baseName := strings.LastIndex(lowerPath, "/") + 1
+
+	prevMatch := -2
+	prev := '/'
+	i := 0
+	for _, q := range query {
+		matched := false
+		for i < len(lowerPath) {
+			r, size := utf8.DecodeRuneInString(lowerPath[i:])
+			at := i
+			i += size
+			if r != q {
+				prev = r
+				continue
+			}
+
+			score++
+			if at == prevMatch+1 {
+				score += findConsecutiveBonus
+			}
+			if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+				score += findSegmentBonus
+			}
+			if at >= baseName {
+				score += findBaseNameBonus
+			}
+			prevMatch = at + size - 1
+			prev = r
+			matched = true
+			break
+		}
+		if !matched {
+			return 0, false
+		}
+	}
+
+	return score, true
+}
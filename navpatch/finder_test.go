@@ -0,0 +1,68 @@
+package navpatch
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestFuzzyScore(c *C) {
+	_, ok := fuzzyScore("zz", "foo/a.go")
+	c.Assert(ok, Equals, false)
+	// Characters must come in order.
+	_, ok = fuzzyScore("ba", "ab.go")
+	c.Assert(ok, Equals, false)
+	_, ok = fuzzyScore("README", "docs/readme")
+	c.Assert(ok, Equals, true)
+	score, ok := fuzzyScore("", "foo/a.go")
+	c.Assert(ok, Equals, true)
+	c.Assert(score, Equals, 0)
+
+	for _, t := range []struct {
+		query, better, worse string
+	}{
+		// Consecutive characters.
+		{"abc", "abc.go", "axbxc.go"},
+		// Start of a path segment or word.
+		{"b", "a/b.go", "a/ab.go"},
+		{"t", "a_test.go", "a_xtx.go"},
+		// Base name.
+		{"foo", "x/foo.go", "foo/x.go"},
+	} {
+		better, ok := fuzzyScore(t.query, t.better)
+		c.Assert(ok, Equals, true)
+		worse, ok := fuzzyScore(t.query, t.worse)
+		c.Assert(ok, Equals, true)
+		c.Assert(better > worse, Equals, true, Commentf("%q: %s (%d) vs %s (%d)", t.query, t.better, better, t.worse, worse))
+	}
+
+	// Lowercased, İ is shorter; b is still in the base name.
+	score, _ = fuzzyScore("b", "İİİİİİ/a/b")
+	ascii, _ := fuzzyScore("b", "xxxxxx/a/b")
+	c.Assert(score, Equals, ascii)
+}
+
+func (s *PatchS) TestFindFiles(c *C) {
+	nav := testNavigator(c, testTree, `diff --git a/foo/b.go b/foo/b.go
+index 1111111..2222222 100644
+--- a/foo/b.go
++++ b/foo/b.go
+@@ -3 +3 @@
+-func B() {}
++func B2() {}
+`)
+
+	results := nav.findFiles("go", 10)
+	c.Assert(results, DeepEquals, []findResult{
+		// Changed files rank higher.
+		{Path: "foo/b.go", Changed: true, score: results[0].score},
+		{Path: "foo/a.go", score: results[1].score},
+	})
+	c.Assert(nav.findFiles("go", 1), HasLen, 1)
+	c.Assert(nav.findFiles("zz", 10), HasLen, 0)
+
+	w := serveTest(nav, "/?find=fa")
+	var served []findResult
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &served), IsNil)
+	c.Assert(served, DeepEquals, []findResult{{Path: "foo/a.go"}})
+}
@@ -0,0 +1,241 @@
+package navpatch
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Kinds of files that reviews usually skip.
+const (
+	fileGenerated = "generated"
+	fileVendored  = "vendored"
+)
+
+// attributesFile is the file, at the root of the tree, that declares more
+// generated or vendored paths. Each line has a pattern and one or more of
+// the attributes generated, vendored, -generated and -vendored, as in
+//
+//	# Comments start with #.
+//	*.pb.go         generated
+//	api/gen/**      generated
+//	third_party/    -vendored
+//
+// Patterns without a slash match the name of any file or folder, and
+// everything inside matching folders is matched too. Later lines win.
+const attributesFile = ".navpatchattributes"
+
+var defaultAttributes = parseAttributes(`
+*.pb.go             generated
+*.pb.gw.go          generated
+*_pb2.py            generated
+*_pb2_grpc.py       generated
+*.min.js            generated
+*.min.css           generated
+go.sum              generated
+package-lock.json   generated
+yarn.lock           generated
+pnpm-lock.yaml      generated
+Cargo.lock          generated
+Gemfile.lock        generated
+composer.lock       generated
+poetry.lock         generated
+vendor              vendored
+third_party         vendored
+node_modules        vendored
+`)
+
+// The header that Go tools write at the top of generated files.
+var goGeneratedHeader = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// Minified scripts and styles have few, very long lines.
+const minifiedLineLength = 500
+
+type attributeRule struct {
+	pattern string
+	attr    string
+	unset   bool
+}
+
+// fileAttributes are the rules from an attributes file.
+type fileAttributes []attributeRule
+
+func parseAttributes(text string) fileAttributes {
+	var ret fileAttributes
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pattern := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(fields[0], "/"), "/**"), "/")
+		for _, attr := range fields[1:] {
+			unset := strings.HasPrefix(attr, "-")
+			attr = strings.TrimPrefix(attr, "-")
+			if attr != fileGenerated && attr != fileVendored {
+				continue
+			}
+			ret = append(ret, attributeRule{pattern, attr, unset})
+		}
+	}
+	return ret
+}
+
+// readAttributes reads the attributes file of a tree, as it is after the
+// patch.
+func readAttributes(tree TreeEntry, changes map[string]*DiffStats) fileAttributes {
+	root, ok := tree.(*TreeFolder)
+	if !ok {
+		return nil
+	}
+	for _, entry := range root.Entries {
+		f, ok := entry.(*TreeFile)
+		if !ok || f.Name() != attributesFile {
+			continue
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return nil
+		}
+		if stats := changes[attributesFile]; stats != nil {
+			if stats.Removed {
+				return nil
+			}
+			_, contents = splitDiff(contents)
+		}
+		return parseAttributes(contents)
+	}
+	return nil
+}
+
+func (rules fileAttributes) apply(set map[string]bool, p string) {
+	parts := strings.Split(p, "/")
+	for _, r := range rules {
+		matched := false
+		for i := range parts {
+			if strings.Contains(r.pattern, "/") {
+				matched, _ = path.Match(r.pattern, strings.Join(parts[:i+1], "/"))
+			} else {
+				matched, _ = path.Match(r.pattern, parts[i])
+			}
+			if matched {
+				break
+			}
+		}
+		if matched {
+			set[r.attr] = !r.unset
+		}
+	}
+}
+
+// kind tells whether the file or folder at path is vendored or generated, by
+// the default rules, then by its contents, if they look generated, and then
+// by rules.
+func (rules fileAttributes) kind(path string, generatedContents bool) string {
+	set := map[string]bool{}
+	defaultAttributes.apply(set, path)
+	if generatedContents {
+		set[fileGenerated] = true
+	}
+	rules.apply(set, path)
+
+	switch {
+	case set[fileVendored]:
+		return fileVendored
+	case set[fileGenerated]:
+		return fileGenerated
+	}
+	return ""
+}
+
+// looksGenerated reports whether some text of a file looks generated: Go
+// files with the standard header and minified scripts and styles. If top,
+// the text is the start of the file; else, the header can't be told.
+func looksGenerated(name, text string, top bool) bool {
+	switch path.Ext(name) {
+	case ".go":
+		if !top {
+			return false
+		}
+		// As for the go command, the header must come before anything but
+		// comments and blank lines.
+		inComment := false
+		for _, line := range splitLines(text) {
+			line = strings.TrimSpace(line)
+			switch {
+			case inComment:
+				inComment = !strings.Contains(line, "*/")
+			case line == "":
+			case strings.HasPrefix(line, "/*"):
+				inComment = !strings.Contains(line[2:], "*/")
+			case strings.HasPrefix(line, "//"):
+				if goGeneratedHeader.MatchString(line) {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	case ".js", ".css":
+		lines := strings.Count(text, "\n") + 1
+		return len(text)/lines > minifiedLineLength
+	}
+	return false
+}
+
+// patchText returns the text of a changed file that its patch shows, as it
+// is after the patch or, for removed files, before it, and whether it's the
+// start of the file. Files are never read for it, as they can be remote.
+func patchText(stats *DiffStats) (text string, top bool) {
+	var buf bytes.Buffer
+	for _, chunk := range stats.Chunks {
+		if stats.Removed {
+			buf.Write(chunk.Old)
+		} else {
+			buf.Write(chunk.New)
+		}
+	}
+	return buf.String(), len(stats.Chunks) > 0 && stats.Chunks[0].Line <= 1
+}
+
+// markGenerated sets whether the changed files are generated or vendored, by
+// their paths and what their patches show of them.
+func markGenerated(tree TreeEntry, changes map[string]*DiffStats) {
+	rules := readAttributes(tree, changes)
+	for p, stats := range changes {
+		text, top := patchText(stats)
+		switch rules.kind(p, !stats.Binary && looksGenerated(p, text, top)) {
+		case fileGenerated:
+			stats.Generated = true
+		case fileVendored:
+			stats.Vendored = true
+		}
+	}
+}
+
+// shownStats returns the stats of a file or folder as shown with opts:
+// folders count the lines of their generated and vendored files only if
+// ShowGenerated.
+func (opts viewOptions) shownStats(s *DiffStats) *DiffStats {
+	if s == nil || !opts.ShowGenerated || s.GeneratedAdditions+s.GeneratedDeletions == 0 {
+		return s
+	}
+	ret := *s
+	ret.Additions += ret.GeneratedAdditions
+	ret.Deletions += ret.GeneratedDeletions
+	return &ret
+}
+
+// entryKind tells whether the file or folder at path is generated or
+// vendored.
+func (nav *Navigator) entryKind(path string) string {
+	if stats := nav.Changes[path]; stats != nil {
+		switch {
+		case stats.Vendored:
+			return fileVendored
+		case stats.Generated:
+			return fileGenerated
+		}
+	}
+	return nav.attributes.kind(path, false)
+}
@@ -0,0 +1,375 @@
+package navpatch
+
+import (
+	"bytes"
+	"html"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aryann/difflib"
+)
+
+// A textRange is a byte range of a line. Syntax ranges have the class of
+// their token.
+type textRange struct {
+	start, end int
+	class      string
+}
+
+// Token classes.
+const (
+	tokComment = "tok-comment"
+	tokString  = "tok-string"
+	tokKeyword = "tok-keyword"
+	tokNumber  = "tok-number"
+)
+
+type stringDelim struct {
+	open, close string
+	multiline   bool
+	escapes     bool
+}
+
+// A language tells how to find the tokens that are highlighted in its
+// source files.
+type language struct {
+	lineComments  []string
+	blockComments [][2]string
+	strings       []stringDelim
+	keywords      map[string]bool
+	ignoreCase    bool
+	// Line comments only start after a space, as in shell, where ${#var}
+	// isn't one.
+	commentsAfterSpace bool
+	// Lines starting with # are headings, as in Markdown.
+	headings bool
+}
+
+func words(s string) map[string]bool {
+	ret := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		ret[w] = true
+	}
+	return ret
+}
+
+var (
+	cStyleComments = [][2]string{{"/*", "*/"}}
+	quotedStrings  = []stringDelim{{`"`, `"`, false, true}, {`'`, `'`, false, true}}
+)
+
+var jsLanguage = &language{
+	lineComments:  []string{"//"},
+	blockComments: cStyleComments,
+	strings:       append([]stringDelim{{"`", "`", true, true}}, quotedStrings...),
+	keywords: words(`abstract as async await break case catch class const continue
+		debugger default delete do else enum export extends false finally for from
+		function get if implements import in instanceof interface let new null of
+		private protected public readonly return set static super switch this throw
+		true try type typeof undefined var void while with yield`),
+}
+
+var shellLanguage = &language{
+	lineComments:       []string{"#"},
+	commentsAfterSpace: true,
+	strings:            []stringDelim{{`"`, `"`, true, true}, {`'`, `'`, true, false}},
+	keywords: words(`case do done elif else esac exit export fi for function if in
+		local return select then until while`),
+}
+
+// Languages by file extension.
+var languages = map[string]*language{
+	".go": {
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		strings:       append([]stringDelim{{"`", "`", true, false}}, quotedStrings...),
+		keywords: words(`break case chan const continue default defer else fallthrough
+			for func go goto if import interface map package range return select struct
+			switch type var true false nil iota append cap close complex copy delete imag
+			len make new panic print println real recover bool byte complex64 complex128
+			error float32 float64 int int8 int16 int32 int64 rune string uint uint8
+			uint16 uint32 uint64 uintptr`),
+	},
+	".js":  jsLanguage,
+	".jsx": jsLanguage,
+	".mjs": jsLanguage,
+	".ts":  jsLanguage,
+	".tsx": jsLanguage,
+	".py": {
+		lineComments: []string{"#"},
+		strings: append([]stringDelim{
+			{`"""`, `"""`, true, true},
+			{`'''`, `'''`, true, true},
+		}, quotedStrings...),
+		keywords: words(`and as assert async await break class continue def del elif
+			else except False finally for from global if import in is lambda None
+			nonlocal not or pass raise return True try while with yield self`),
+	},
+	".yaml": yamlLanguage,
+	".yml":  yamlLanguage,
+	".json": {
+		strings:  []stringDelim{{`"`, `"`, false, true}},
+		keywords: words(`true false null`),
+	},
+	".md":       markdownLanguage,
+	".markdown": markdownLanguage,
+	".sh":       shellLanguage,
+	".bash":     shellLanguage,
+	".zsh":      shellLanguage,
+	".sql": {
+		lineComments:  []string{"--"},
+		blockComments: cStyleComments,
+		strings:       []stringDelim{{`'`, `'`, true, false}, {`"`, `"`, false, false}},
+		ignoreCase:    true,
+		keywords: words(`add all alter and as asc begin between by case check column
+			commit constraint create cross default delete desc distinct drop else end
+			exists false foreign from full group having if in index inner insert into
+			is join key left like limit not null offset on or order outer primary
+			references right rollback select set table then true union unique update
+			values view when where with`),
+	},
+	".proto": {
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		strings:       quotedStrings,
+		keywords: words(`syntax package import option message enum service rpc returns
+			stream repeated optional required oneof map reserved extend extensions to max
+			true false double float int32 int64 uint32 uint64 sint32 sint64 fixed32
+			fixed64 sfixed32 sfixed64 bool string bytes`),
+	},
+}
+
+var yamlLanguage = &language{
+	lineComments: []string{"#"},
+	strings:      quotedStrings,
+	keywords:     words(`true false null yes no on off True False Null`),
+}
+
+var markdownLanguage = &language{
+	strings:  []stringDelim{{"```", "```", true, false}, {"`", "`", false, false}},
+	headings: true,
+}
+
+func languageFor(name string) *language {
+	return languages[strings.ToLower(path.Ext(name))]
+}
+
+// highlight splits text in lines and returns the syntax ranges of each.
+// Tokens spanning several lines, like block comments, are split in a range
+// per line.
+func highlight(text string, lang *language) [][]textRange {
+	var tokens []textRange
+	add := func(start, end int, class string) {
+		if end > start {
+			tokens = append(tokens, textRange{start, end, class})
+		}
+	}
+
+	lineStart := func(i int) bool { return i == 0 || text[i-1] == '\n' }
+	isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+
+	for i := 0; i < len(text); {
+		if lang.headings && lineStart(i) && text[i] == '#' {
+			end := lineEnd(text, i)
+			add(i, end, tokKeyword)
+			i = end
+			continue
+		}
+
+		if end, ok := matchComment(text, i, lang); ok {
+			add(i, end, tokComment)
+			i = end
+			continue
+		}
+
+		if end, ok := matchString(text, i, lang); ok {
+			add(i, end, tokString)
+			i = end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if isWord(r) {
+			end := i + size
+			for end < len(text) {
+				r2, size2 := utf8.DecodeRuneInString(text[end:])
+				if !isWord(r2) && !(unicode.IsDigit(r) && r2 == '.') {
+					break
+				}
+				end += size2
+			}
+			word := text[i:end]
+			if unicode.IsDigit(r) {
+				add(i, end, tokNumber)
+			} else if lang.keywords[word] || (lang.ignoreCase && lang.keywords[strings.ToLower(word)]) {
+				add(i, end, tokKeyword)
+			}
+			i = end
+			continue
+		}
+
+		i += size
+	}
+
+	return splitRangesInLines(text, tokens)
+}
+
+func lineEnd(text string, i int) int {
+	if j := strings.IndexByte(text[i:], '\n'); j != -1 {
+		return i + j
+	}
+	return len(text)
+}
+
+func matchComment(text string, i int, lang *language) (end int, ok bool) {
+	for _, c := range lang.blockComments {
+		if strings.HasPrefix(text[i:], c[0]) {
+			j := strings.Index(text[i+len(c[0]):], c[1])
+			if j == -1 {
+				return len(text), true
+			}
+			return i + len(c[0]) + j + len(c[1]), true
+		}
+	}
+	for _, c := range lang.lineComments {
+		if strings.HasPrefix(text[i:], c) && (!lang.commentsAfterSpace || i == 0 || unicode.IsSpace(rune(text[i-1]))) {
+			return lineEnd(text, i), true
+		}
+	}
+	return 0, false
+}
+
+func matchString(text string, i int, lang *language) (end int, ok bool) {
+	for _, s := range lang.strings {
+		if !strings.HasPrefix(text[i:], s.open) {
+			continue
+		}
+		for j := i + len(s.open); j < len(text); j++ {
+			switch {
+			case s.escapes && text[j] == '\\' && (s.multiline || !strings.HasPrefix(text[j+1:], "\n")):
+				// An escaped line end still ends single-line strings.
+				j++
+			case text[j] == '\n' && !s.multiline:
+				return j, true
+			case strings.HasPrefix(text[j:], s.close):
+				return j + len(s.close), true
+			}
+		}
+		return len(text), true
+	}
+	return 0, false
+}
+
+// splitRangesInLines turns ranges of text in ranges of each of its lines.
+func splitRangesInLines(text string, ranges []textRange) [][]textRange {
+	lines := strings.Split(text, "\n")
+	ret := make([][]textRange, len(lines))
+
+	lineStarts := make([]int, len(lines))
+	offset := 0
+	for n, line := range lines {
+		lineStarts[n] = offset
+		offset += len(line) + 1
+	}
+
+	for _, r := range ranges {
+		n := sort.SearchInts(lineStarts, r.start+1) - 1
+		for ; n < len(lines) && lineStarts[n] < r.end; n++ {
+			start, end := r.start-lineStarts[n], r.end-lineStarts[n]
+			if start < 0 {
+				start = 0
+			}
+			if end > len(lines[n]) {
+				end = len(lines[n])
+			}
+			if end > start {
+				ret[n] = append(ret[n], textRange{start, end, r.class})
+			}
+		}
+	}
+
+	return ret
+}
+
+// highlightRecords returns the syntax ranges of each record of a diff of a
+// file called name. Old and new files are highlighted whole, so that tokens
+// spanning several lines are found. It returns nil for unknown languages.
+func highlightRecords(records []difflib.DiffRecord, name string) [][]textRange {
+	lang := languageFor(name)
+	if lang == nil {
+		return nil
+	}
+
+	var old, new []string
+	for _, rec := range records {
+		if rec.Delta != difflib.RightOnly {
+			old = append(old, rec.Payload)
+		}
+		if rec.Delta != difflib.LeftOnly {
+			new = append(new, rec.Payload)
+		}
+	}
+	oldRanges := highlight(strings.Join(old, "\n"), lang)
+	newRanges := highlight(strings.Join(new, "\n"), lang)
+
+	ret := make([][]textRange, len(records))
+	oldNum, newNum := 0, 0
+	for i, rec := range records {
+		switch rec.Delta {
+		case difflib.LeftOnly:
+			ret[i] = oldRanges[oldNum]
+			oldNum++
+		case difflib.RightOnly:
+			ret[i] = newRanges[newNum]
+			newNum++
+		default:
+			ret[i] = newRanges[newNum]
+			oldNum++
+			newNum++
+		}
+	}
+	return ret
+}
+
+// renderLine escapes line, wrapping its syntax ranges in spans of their
+// class and its changed ranges in spans of class "changed".
+func renderLine(line string, syntax, changed []textRange) string {
+	if len(syntax) == 0 && len(changed) == 0 {
+		return html.EscapeString(line)
+	}
+
+	bounds := []int{0, len(line)}
+	for _, r := range append(syntax[:len(syntax):len(syntax)], changed...) {
+		bounds = append(bounds, r.start, r.end)
+	}
+	sort.Ints(bounds)
+
+	inRange := func(ranges []textRange, i int) *textRange {
+		for j := range ranges {
+			if ranges[j].start <= i && i < ranges[j].end {
+				return &ranges[j]
+			}
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for k := 1; k < len(bounds); k++ {
+		start, end := bounds[k-1], bounds[k]
+		if start == end {
+			continue
+		}
+		text := html.EscapeString(line[start:end])
+		if r := inRange(syntax, start); r != nil {
+			text = `<span class="` + r.class + `">` + text + `</span>`
+		}
+		if inRange(changed, start) != nil {
+			text = `<span class="changed">` + text + `</span>`
+		}
+		buf.WriteString(text)
+	}
+	return buf.String()
+}
@@ -0,0 +1,75 @@
+package navpatch
+
+import (
+	"github.com/aryann/difflib"
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestHighlight(c *C) {
+	for _, t := range []struct {
+		name, text string
+		ranges     [][]textRange
+	}{
+		{"a.go", "x := \"a\" // c\nreturn 1.5", [][]textRange{
+			{{5, 8, tokString}, {9, 13, tokComment}},
+			{{0, 6, tokKeyword}, {7, 10, tokNumber}},
+		}},
+		// Block comments are split in a range per line.
+		{"a.go", "a /* b\nc */ d", [][]textRange{
+			{{2, 6, tokComment}},
+			{{0, 4, tokComment}},
+		}},
+		// Single-line strings end at the end of the line.
+		{"a.go", "\"abc\nx", [][]textRange{
+			{{0, 4, tokString}},
+			nil,
+		}},
+		{"a.go", `"a\"b" c`, [][]textRange{{{0, 6, tokString}}}},
+		{"a.go", "\"a\\\nb", [][]textRange{{{0, 3, tokString}}, nil}},
+		// In shell, # only starts a comment after a space.
+		{"a.sh", "${#x} # c", [][]textRange{{{6, 9, tokComment}}}},
+		{"a.py", "x#c", [][]textRange{{{1, 3, tokComment}}}},
+		{"a.yaml", "a: b#c", [][]textRange{{{4, 6, tokComment}}}},
+		{"a.go", "x#c", [][]textRange{nil}},
+		{"a.sql", "SELECT x", [][]textRange{{{0, 6, tokKeyword}}}},
+		{"a.md", "# Title\ntext", [][]textRange{{{0, 7, tokKeyword}}, nil}},
+	} {
+		c.Assert(highlight(t.text, languageFor(t.name)), DeepEquals, t.ranges, Commentf("%s: %q", t.name, t.text))
+	}
+}
+
+func (s *PatchS) TestHighlightRecords(c *C) {
+	c.Assert(highlightRecords([]difflib.DiffRecord{{Payload: "x"}}, "README"), IsNil)
+
+	// The deleted line opens a comment only in the old file.
+	ranges := highlightRecords([]difflib.DiffRecord{
+		{Payload: "/* a", Delta: difflib.LeftOnly},
+		{Payload: "b */", Delta: difflib.Common},
+		{Payload: "// c", Delta: difflib.RightOnly},
+	}, "a.go")
+	c.Assert(ranges, DeepEquals, [][]textRange{
+		{{0, 4, tokComment}},
+		nil,
+		{{0, 4, tokComment}},
+	})
+}
+
+func (s *PatchS) TestRenderLine(c *C) {
+	c.Assert(renderLine("a<b", nil, nil), Equals, "a&lt;b")
+
+	// Overlapping syntax and changed ranges nest.
+	c.Assert(renderLine("var x = 1",
+		[]textRange{{0, 3, tokKeyword}, {8, 9, tokNumber}},
+		[]textRange{{start: 4, end: 9}},
+	), Equals, `<span class="tok-keyword">var</span> `+
+		`<span class="changed">x = </span>`+
+		`<span class="changed"><span class="tok-number">1</span></span>`)
+
+	// A changed range inside a token splits it.
+	c.Assert(renderLine(`"ab"`,
+		[]textRange{{0, 4, tokString}},
+		[]textRange{{start: 1, end: 2}},
+	), Equals, `<span class="tok-string">&#34;</span>`+
+		`<span class="changed"><span class="tok-string">a</span></span>`+
+		`<span class="tok-string">b&#34;</span>`)
+}
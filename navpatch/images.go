@@ -0,0 +1,48 @@
+package navpatch
+
+import (
+	"encoding/base64"
+	"html/template"
+	"path"
+	"strings"
+)
+
+var imageMIMETypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".svg":  "image/svg+xml",
+}
+
+func imageMIMEType(name string) string {
+	return imageMIMETypes[strings.ToLower(path.Ext(name))]
+}
+
+type tplImage struct {
+	Old     template.URL
+	New     template.URL
+	Changed bool
+}
+
+// makeTplImage returns the old and new versions of an image file as data
+// URIs, or nil if name isn't an image's.
+func makeTplImage(name string, contents *BinaryContents, stats *DiffStats) *tplImage {
+	mimeType := imageMIMEType(name)
+	if mimeType == "" {
+		return nil
+	}
+
+	dataURI := func(bs []byte) template.URL {
+		if len(bs) == 0 {
+			return ""
+		}
+		return template.URL("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(bs))
+	}
+
+	return &tplImage{
+		Old:     dataURI(contents.Old),
+		New:     dataURI(contents.New),
+		Changed: stats != nil,
+	}
+}
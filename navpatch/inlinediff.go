@@ -0,0 +1,124 @@
+package navpatch
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/aryann/difflib"
+)
+
+// Lines longer than this, in tokens, aren't diffed word by word; the LCS
+// matrix difflib builds would be too big.
+const maxInlineDiffTokens = 500
+
+// diffLinesHTML returns the escaped payload of each record, highlighted as
+// the source of a file called name. Deleted and added lines of a change are
+// paired in order, and the words that changed between each pair are wrapped
+// in a span with class "changed".
+func diffLinesHTML(records []difflib.DiffRecord, name string) []string {
+	syntax := highlightRecords(records, name)
+	changed := make([][]textRange, len(records))
+
+	for i := 0; i < len(records); {
+		if records[i].Delta == difflib.Common {
+			i++
+			continue
+		}
+
+		var dels, adds []int
+		for ; i < len(records) && records[i].Delta != difflib.Common; i++ {
+			if records[i].Delta == difflib.LeftOnly {
+				dels = append(dels, i)
+			} else {
+				adds = append(adds, i)
+			}
+		}
+		for j := 0; j < len(dels) && j < len(adds); j++ {
+			old, new, ok := inlineDiff(records[dels[j]].Payload, records[adds[j]].Payload)
+			if ok {
+				changed[dels[j]], changed[adds[j]] = old, new
+			}
+		}
+	}
+
+	ret := make([]string, len(records))
+	for i, rec := range records {
+		var lineSyntax []textRange
+		if syntax != nil {
+			lineSyntax = syntax[i]
+		}
+		ret[i] = renderLine(rec.Payload, lineSyntax, changed[i])
+	}
+	return ret
+}
+
+// inlineDiff diffs two lines word by word and returns the ranges of each that
+// changed. ok is false if the lines have nothing but whitespace in common, in
+// which case highlighting would only add noise.
+func inlineDiff(oldLine, newLine string) (oldChanged, newChanged []textRange, ok bool) {
+	oldTokens, newTokens := tokenize(oldLine), tokenize(newLine)
+	if len(oldTokens) > maxInlineDiffTokens || len(newTokens) > maxInlineDiffTokens {
+		return nil, nil, false
+	}
+
+	// Adjacent changed tokens are merged in a single range.
+	extend := func(ranges []textRange, start, end int) []textRange {
+		if n := len(ranges); n > 0 && ranges[n-1].end == start {
+			ranges[n-1].end = end
+			return ranges
+		}
+		return append(ranges, textRange{start: start, end: end})
+	}
+
+	oldPos, newPos := 0, 0
+	for _, rec := range difflib.Diff(oldTokens, newTokens) {
+		n := len(rec.Payload)
+		switch rec.Delta {
+		case difflib.LeftOnly:
+			oldChanged = extend(oldChanged, oldPos, oldPos+n)
+			oldPos += n
+		case difflib.RightOnly:
+			newChanged = extend(newChanged, newPos, newPos+n)
+			newPos += n
+		default:
+			if strings.TrimSpace(rec.Payload) != "" {
+				ok = true
+			}
+			oldPos += n
+			newPos += n
+		}
+	}
+
+	return oldChanged, newChanged, ok
+}
+
+// tokenize splits a line in words, runs of whitespace and single punctuation
+// characters.
+func tokenize(line string) []string {
+	var ret []string
+
+	class := func(r rune) int {
+		switch {
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		}
+		return 0
+	}
+
+	start, prev := 0, -1
+	for i, r := range line {
+		c := class(r)
+		if i > start && (c != prev || c == 0) {
+			ret = append(ret, line[start:i])
+			start = i
+		}
+		prev = c
+	}
+	if start < len(line) {
+		ret = append(ret, line[start:])
+	}
+
+	return ret
+}
@@ -0,0 +1,190 @@
+package navpatch
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aryann/difflib"
+)
+
+// Moved blocks have at least this many lines, with at least this many
+// letters and digits between them, so that common lines like closing braces
+// or "return err" aren't taken for moves.
+const (
+	minMovedLines = 3
+	minMovedChars = 20
+)
+
+// A MovedBlock is a block of lines that a patch deleted in one place and
+// added in another, in the same file or in a different one. Lines that only
+// changed their indentation are still the same.
+type MovedBlock struct {
+	// Whether the block was moved out of the file; else, it was moved in.
+	Out bool
+	// The first line of the block, in the old file if Out and in the new
+	// file if not, and how many lines it has.
+	Line  int
+	Count int
+	// Where the block was moved to or from, and its first line there.
+	OtherPath string
+	OtherLine int
+}
+
+// A patchLine is a line deleted or added by a patch.
+type patchLine struct {
+	path string
+	num  int
+	// The line without leading, trailing or repeated whitespace.
+	key string
+	// Lines are consecutive if they have the same run.
+	run   int
+	moved bool
+}
+
+// detectMoves finds the blocks of lines that the patch moves, adds them to
+// the stats of the files at both ends, and takes them out of their deletions
+// and additions. Lines are diffed ignoring the whitespace that ws ignores.
+func detectMoves(changes map[string]*DiffStats, ws Whitespace) {
+	var paths []string
+	for path, stats := range changes {
+		if len(stats.Chunks) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var dels, adds []patchLine
+	run := 0
+	for _, path := range paths {
+		offset := 0
+		for _, chunk := range changes[path].Chunks {
+			oldNum := chunk.Line
+			if oldNum < 1 {
+				oldNum = 1
+			}
+			newNum := oldNum + offset
+			atoms := diffLines(
+				strings.Split(string(chunk.Old), "\n"),
+				strings.Split(string(chunk.New), "\n"),
+				ws,
+			)
+			lastDelta := difflib.Common
+			for _, a := range atoms {
+				if a.Delta != lastDelta {
+					run++
+					lastDelta = a.Delta
+				}
+				line := patchLine{path: path, key: strings.Join(strings.Fields(a.Payload), " "), run: run}
+				switch a.Delta {
+				case difflib.LeftOnly:
+					line.num = oldNum
+					dels = append(dels, line)
+					oldNum++
+				case difflib.RightOnly:
+					line.num = newNum
+					adds = append(adds, line)
+					newNum++
+				default:
+					oldNum++
+					newNum++
+				}
+			}
+			offset += strings.Count(string(chunk.New), "\n") - strings.Count(string(chunk.Old), "\n")
+		}
+	}
+
+	addsByKey := map[string][]int{}
+	for j, line := range adds {
+		addsByKey[line.key] = append(addsByKey[line.key], j)
+	}
+
+	for i := 0; i < len(dels); {
+		// Blocks don't start with blank lines or lone braces.
+		if !hasAlphanumeric(dels[i].key) {
+			i++
+			continue
+		}
+
+		bestJ, bestLen := 0, 0
+		for _, j := range addsByKey[dels[i].key] {
+			n := 0
+			for i+n < len(dels) && j+n < len(adds) &&
+				dels[i+n].run == dels[i].run && adds[j+n].run == adds[j].run &&
+				!dels[i+n].moved && !adds[j+n].moved &&
+				dels[i+n].key == adds[j+n].key {
+				n++
+			}
+			if n > bestLen {
+				bestJ, bestLen = j, n
+			}
+		}
+
+		chars := 0
+		for _, line := range dels[i : i+bestLen] {
+			chars += countAlphanumeric(line.key)
+		}
+		if bestLen < minMovedLines || chars < minMovedChars {
+			i++
+			continue
+		}
+
+		del, add := dels[i], adds[bestJ]
+		for n := 0; n < bestLen; n++ {
+			dels[i+n].moved = true
+			adds[bestJ+n].moved = true
+		}
+
+		from, to := changes[del.path], changes[add.path]
+		from.Moves = append(from.Moves, MovedBlock{Out: true, Line: del.num, Count: bestLen, OtherPath: add.path, OtherLine: add.num})
+		from.MovedOut += bestLen
+		from.Deletions -= bestLen
+		to.Moves = append(to.Moves, MovedBlock{Out: false, Line: add.num, Count: bestLen, OtherPath: del.path, OtherLine: del.num})
+		to.MovedIn += bestLen
+		to.Additions -= bestLen
+
+		i += bestLen
+	}
+}
+
+func hasAlphanumeric(s string) bool {
+	return countAlphanumeric(s) > 0
+}
+
+func countAlphanumeric(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// A tplMove is a moved block with a link to its other end.
+type tplMove struct {
+	MovedBlock
+	Link string
+}
+
+// Moves returns the moved blocks of the file at path, with links to their
+// other ends.
+func (d tplTreeData) Moves(path string, stats *DiffStats) []tplMove {
+	if stats == nil {
+		return nil
+	}
+	var ret []tplMove
+	for _, m := range stats.Moves {
+		anchor := "#L" + strconv.Itoa(m.OtherLine)
+		if m.Out {
+			anchor = "#R" + strconv.Itoa(m.OtherLine)
+		}
+		link := anchor
+		if "/"+m.OtherPath != path {
+			link = d.LinksPrefix + "/" + m.OtherPath + d.LinksSuffix + anchor
+		}
+		ret = append(ret, tplMove{m, link})
+	}
+	return ret
+}
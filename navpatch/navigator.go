@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"golang.org/x/codereview/patch"
 
@@ -15,10 +18,29 @@ type Navigator struct {
 	RawPatch []byte
 	BaseDir  TreeEntry
 	Changes  map[string]*DiffStats
+	// The whitespace changes ignored in BaseDir and Changes.
+	Whitespace Whitespace
+
+	// Rules from the tree's attributes file.
+	attributes fileAttributes
+
+	apiOnce sync.Once
+	api     []packageAPI
+
+	// To make the navigators for other whitespace settings.
+	repo       Repository
+	variantsMu sync.Mutex
+	variants   map[Whitespace]*Navigator
 }
 
 func NewNavigator(r Repository, rawPatch []byte) (*Navigator, error) {
-	patchSet, err := patch.Parse(rawPatch)
+	return NewNavigatorIgnoring(r, rawPatch, WhitespaceExact)
+}
+
+// NewNavigatorIgnoring is like NewNavigator, but ignores the whitespace
+// changes that ws tells.
+func NewNavigatorIgnoring(r Repository, rawPatch []byte, ws Whitespace) (*Navigator, error) {
+	patchSet, err := ParsePatch(rawPatch)
 	if err != nil {
 		return nil, fmt.Errorf("parsing patch: %s", err)
 	}
@@ -28,21 +50,203 @@ func NewNavigator(r Repository, rawPatch []byte) (*Navigator, error) {
 		return nil, err
 	}
 
-	changes := ApplyChangesToTree(patchSet, tree)
+	changes := ApplyChangesToTreeIgnoring(patchSet, tree, ws)
 
 	return &Navigator{
-		RawPatch: rawPatch,
-		BaseDir:  tree,
-		Changes:  changes,
+		RawPatch:   rawPatch,
+		BaseDir:    tree,
+		Changes:    changes,
+		Whitespace: ws,
+		attributes: readAttributes(tree, changes),
+		repo:       r,
 	}, nil
 }
 
+// ignoring returns a navigator for the same patch and repository that
+// ignores the whitespace changes that ws tells. It's made the first time
+// it's asked for.
+func (nav *Navigator) ignoring(ws Whitespace) (*Navigator, error) {
+	if ws == nav.Whitespace || nav.repo == nil {
+		return nav, nil
+	}
+
+	nav.variantsMu.Lock()
+	defer nav.variantsMu.Unlock()
+	if v := nav.variants[ws]; v != nil {
+		return v, nil
+	}
+	v, err := NewNavigatorIgnoring(nav.repo, nav.RawPatch, ws)
+	if err != nil {
+		return nil, err
+	}
+	if nav.variants == nil {
+		nav.variants = map[Whitespace]*Navigator{}
+	}
+	nav.variants[ws] = v
+	return v, nil
+}
+
+// forRequest returns the navigator for the whitespace setting of a request:
+// w=1 ignores all whitespace, b=1 changes in its amount, and w=0 none. If
+// none is set, it's nav's.
+func (nav *Navigator) forRequest(req *http.Request) (*Navigator, error) {
+	q := req.URL.Query()
+	ws := nav.Whitespace
+	if w, ok := q["w"]; ok {
+		ws = WhitespaceExact
+		if w[0] == "1" {
+			ws = IgnoreAllSpace
+		}
+	} else if q.Get("b") == "1" {
+		ws = IgnoreSpaceChange
+	}
+	return nav.ignoring(ws)
+}
+
 func (nav *Navigator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	nav, err := nav.forRequest(req)
+	if err != nil {
+		log.Println(req.URL.Path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	nav.HandleRoot(w, req, req.URL.Path, "")
 }
 
+// viewOptions are per-request settings, taken from the query string, that
+// change how the tree is shown.
+type viewOptions struct {
+	Split bool
+	// Unchanged lines shown around changes; the rest are collapsed. If
+	// negative, nothing is collapsed.
+	Context int
+	// Hide the entries the patch didn't change.
+	ChangedOnly bool
+	// Show chains of folders with a single entry as a single row.
+	Compact bool
+	// How folder entries are sorted: one of the entryOrders, or the
+	// repository's order if empty.
+	Order string
+	// Count generated and vendored files in folder totals and show their
+	// diffs.
+	ShowGenerated bool
+}
+
+// Folder entry orders, by name in the order query parameter.
+var entryOrders = map[string]func(a, b *tplTreeDataLevelEntry) bool{
+	"churn": func(a, b *tplTreeDataLevelEntry) bool {
+		return a.Additions+a.Deletions > b.Additions+b.Deletions
+	},
+	"additions": func(a, b *tplTreeDataLevelEntry) bool {
+		return a.Additions > b.Additions
+	},
+	"changed": func(a, b *tplTreeDataLevelEntry) bool {
+		if a.Changed != b.Changed {
+			return a.Changed
+		}
+		return a.Name < b.Name
+	},
+	"folders": func(a, b *tplTreeDataLevelEntry) bool {
+		return a.IsDir && !b.IsDir
+	},
+}
+
+// The order is remembered in this cookie when it's set in the query.
+const orderCookie = "navpatch-order"
+
+func viewOptionsFromRequest(req *http.Request) viewOptions {
+	q := req.URL.Query()
+	opts := viewOptions{
+		Split:         q.Get("view") == "split",
+		Context:       defaultContext,
+		ChangedOnly:   q.Get("changed") == "1",
+		Compact:       q.Get("compact") == "1",
+		ShowGenerated: q.Get("generated") == "1",
+		Order:         q.Get("order"),
+	}
+	if _, ok := q["order"]; !ok {
+		if c, err := req.Cookie(orderCookie); err == nil {
+			opts.Order = c.Value
+		}
+	}
+	if entryOrders[opts.Order] == nil {
+		opts.Order = ""
+	}
+	if c := q.Get("context"); c == "all" {
+		opts.Context = -1
+	} else if n, err := strconv.Atoi(c); err == nil && n >= 0 {
+		opts.Context = n
+	}
+	return opts
+}
+
+// HandleRoot serves the navigator at path, as HTML or, if the request accepts
+// application/json or has format=json, as JSON, or, for command-line clients
+// and format=text, as plain text. With summary=1, it serves instead the list
+// of changed files, and with search=<query>, the lines that match the query.
+// With w=1 or b=1, whitespace changes are ignored as with git diff -w or -b.
+// Links to other paths are built by appending them to linksPrefix. If
+// linksPrefix has a query string, it's assumed to carry the request's query
+// parameters already; else, they are appended to the links so that view
+// options are kept while navigating.
 func (nav *Navigator) HandleRoot(w http.ResponseWriter, req *http.Request, path string, linksPrefix string) {
-	levels, err := nav.makeTplLevels(path)
+	nav, err := nav.forRequest(req)
+	if err != nil {
+		log.Println(path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if wantsJSON(req) {
+		nav.serveJSON(w, req, path)
+		return
+	}
+
+	if wantsText(req) {
+		nav.serveText(w, req, path)
+		return
+	}
+
+	if lines := req.URL.Query().Get("lines"); lines != "" {
+		nav.serveLines(w, req, path, lines)
+		return
+	}
+
+	if query, ok := req.URL.Query()["find"]; ok {
+		nav.serveFind(w, query[0])
+		return
+	}
+
+	linksSuffix := ""
+	if !strings.Contains(linksPrefix, "?") && req.URL.RawQuery != "" {
+		linksSuffix = "?" + req.URL.RawQuery
+	}
+
+	if order, ok := req.URL.Query()["order"]; ok {
+		cookie := &http.Cookie{Name: orderCookie, Value: order[0], Path: "/"}
+		if entryOrders[order[0]] == nil {
+			cookie.MaxAge = -1
+		}
+		http.SetCookie(w, cookie)
+	}
+
+	if req.URL.Query().Get("summary") != "" {
+		nav.serveSummary(w, req, path, linksPrefix, linksSuffix)
+		return
+	}
+
+	if req.URL.Query().Get("apidiff") != "" {
+		nav.serveAPIDiff(w, req, path, linksPrefix, linksSuffix)
+		return
+	}
+
+	if _, ok := req.URL.Query()["search"]; ok {
+		nav.serveSearch(w, req, path, linksPrefix, linksSuffix)
+		return
+	}
+
+	opts := viewOptionsFromRequest(req)
+	levels, err := nav.makeTplLevels(path, opts)
 	if err == errBadPath {
 		http.NotFound(w, req)
 		return
@@ -51,19 +255,100 @@ func (nav *Navigator) HandleRoot(w http.ResponseWriter, req *http.Request, path
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
-	err = templates.ExecuteTemplate(w, "full", &tplFullData{
+	data := nav.makeTplFullData(path, levels, linksPrefix, linksSuffix, opts)
+	data.TreeData.reqURL = req.URL
+	err = templates.ExecuteTemplate(w, "full", data)
+	if err != nil {
+		log.Println(path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (nav *Navigator) makeTplFullData(
+	path string,
+	levels []tplTreeDataLevel,
+	linksPrefix string,
+	linksSuffix string,
+	opts viewOptions,
+) *tplFullData {
+	pathParts := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	var parentParts []string
+	if len(pathParts) > 0 {
+		parentParts = pathParts[:len(pathParts)-1]
+	}
+
+	return &tplFullData{
 		Title: "navpatch - " + path,
 		TreeData: tplTreeData{
 			Levels:      levels,
 			Nav:         nav,
 			LinksPrefix: linksPrefix,
+			LinksSuffix: linksSuffix,
+			Opts:        opts,
 		},
-		Nav: nav,
-	})
+		Nav:          nav,
+		ExpandStep:   expandStep,
+		CurrentPath:  strings.Join(pathParts, "/"),
+		ParentLink:   linksPrefix + "/" + strings.Join(parentParts, "/") + linksSuffix,
+		ChangedFiles: nav.ChangedFiles(),
+	}
+}
+
+// ChangedFiles returns the paths of the files changed by the patch, in the
+// order they appear in the tree. It's never nil.
+func (nav *Navigator) ChangedFiles() []string {
+	ret := []string{}
+
+	var walk func(prefix string, folder *TreeFolder)
+	walk = func(prefix string, folder *TreeFolder) {
+		for _, entry := range folder.Entries {
+			path := prefix + entry.Name()
+			switch e := entry.(type) {
+			case *TreeFolder:
+				if _, ok := nav.Changes[path]; ok {
+					walk(path+"/", e)
+				}
+			case *TreeFile:
+				if _, ok := nav.Changes[path]; ok {
+					ret = append(ret, path)
+				}
+			}
+		}
+	}
+	if root, ok := nav.BaseDir.(*TreeFolder); ok {
+		walk("", root)
+	}
+
+	return ret
+}
+
+// serveLines serves the table rows for a range of unchanged lines in a
+// collapsed region of the file at path, as "<from>-<to>" record indexes.
+func (nav *Navigator) serveLines(w http.ResponseWriter, req *http.Request, path string, lines string) {
+	levels, err := nav.makeTplLevels(path, viewOptionsFromRequest(req))
+	if err == errBadPath || len(levels) == 0 || levels[len(levels)-1].Body == "" {
+		http.NotFound(w, req)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var from, to int
+	if _, err := fmt.Sscanf(lines, "%d-%d", &from, &to); err != nil {
+		http.Error(w, "bad lines range: "+lines, http.StatusBadRequest)
+		return
+	}
+
+	body := levels[len(levels)-1].Body
+	rows, err := colorifyLines(body, path, viewOptionsFromRequest(req).Split, from, to)
 	if err != nil {
-		log.Println(path, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, rows)
 }
 
 // Splits a request path in parts. Empty parts are discarded.
@@ -82,34 +367,45 @@ func splitReqPath(path string) []string {
 	return parts
 }
 
-func (nav *Navigator) makeTplLevels(path string) ([]tplTreeDataLevel, error) {
+func (nav *Navigator) makeTplLevels(path string, opts viewOptions) ([]tplTreeDataLevel, error) {
 	pathParts := splitReqPath(path)
 
 	var levels []tplTreeDataLevel
 
 	lvlPath := ""
 	tree := nav.BaseDir
-	for _, part := range pathParts {
-		level, nextTree, err := nav.makeTplLevel(lvlPath, part, tree)
+	for len(pathParts) > 0 {
+		level, nextTree, opened, err := nav.makeTplLevel(lvlPath, pathParts, tree, opts)
 		levels = append(levels, level)
 		if err != nil {
 			return levels, err
 		}
 
-		lvlPath += "/" + part
+		// An open compacted folder chain spans several parts.
+		if opened == 0 {
+			opened = 1
+		}
+		lvlPath += "/" + strings.Join(pathParts[:opened], "/")
+		pathParts = pathParts[opened:]
 		tree = nextTree
 	}
 
 	return levels, nil
 }
 
+// makeTplLevel makes the level for tree, whose path is lvlPath. If tree is a
+// folder, the entry named parts[0] is open, and nextTree is it. If it's the
+// start of a compacted folder chain, as many folders of the chain as there
+// are matching parts are open, and opened tells how many.
 func (nav *Navigator) makeTplLevel(
 	lvlPath string,
-	part string,
+	parts []string,
 	tree TreeEntry,
+	opts viewOptions,
 ) (
 	level tplTreeDataLevel,
 	nextTree TreeEntry,
+	opened int,
 	err error,
 ) {
 	level.Path = lvlPath
@@ -123,34 +419,99 @@ func (nav *Navigator) makeTplLevel(
 
 	switch t := tree.(type) {
 	case *TreeFolder:
+		level.IsDir = true
 		dir := t
 		for _, entry := range dir.Entries {
-			_, isDir := entry.(*TreeFolder)
-			isOpen := entry.Name() == part
-			diffStats := nav.Changes[(lvlPath + "/" + entry.Name())[1:]]
+			entryPath := (lvlPath + "/" + entry.Name())[1:]
+			diffStats := nav.Changes[entryPath]
 			if diffStats == nil {
+				// The open entry is kept, so that unchanged files can still be
+				// linked to.
+				if opts.ChangedOnly && entry.Name() != parts[0] {
+					continue
+				}
 				diffStats = &DiffStats{}
 			}
-			level.Entries = append(level.Entries, tplTreeDataLevelEntry{
-				Name:      entry.Name(),
+
+			chain := []TreeEntry{entry}
+			folder, isDir := entry.(*TreeFolder)
+			if isDir && opts.Compact {
+				chain = nav.folderChain(entryPath, folder, opts)
+			}
+			names := make([]string, len(chain))
+			for i, e := range chain {
+				names[i] = e.Name()
+			}
+
+			matching := 0
+			for matching < len(chain) && matching < len(parts) && names[matching] == parts[matching] {
+				matching++
+			}
+			isOpen := matching > 0
+
+			levelEntry := tplTreeDataLevelEntry{
+				Name:      strings.Join(names, "/"),
 				IsDir:     isDir,
 				IsOpen:    isOpen,
-				DiffStats: *diffStats,
-			})
+				Changed:   nav.Changes[entryPath] != nil,
+				Kind:      nav.entryKind(entryPath),
+				DiffStats: *opts.shownStats(diffStats),
+			}
+			if isDir && levelEntry.Changed {
+				// The badge is for the package in the last folder of a chain.
+				chainPath := (lvlPath + "/" + levelEntry.Name)[1:]
+				levelEntry.APIChanges, levelEntry.APIBreaking = nav.apiBadge(chainPath)
+			}
+			level.Entries = append(level.Entries, levelEntry)
 			if isOpen {
-				nextTree = entry
+				nextTree = chain[matching-1]
+				opened = matching
 			}
 		}
+		if less := entryOrders[opts.Order]; less != nil {
+			sort.Stable(levelEntries{level.Entries, less})
+		}
+		level.Packages = nav.packageOutlines(lvlPath, dir)
 	case *TreeFile:
+		level.Stats = nav.Changes[lvlPath[1:]]
+		level.Kind = nav.entryKind(lvlPath[1:])
+		if t.IsBinary() {
+			level.Binary, err = t.BinaryContents()
+			if err != nil {
+				level.Error = err
+				break
+			}
+			level.Image = makeTplImage(t.Name(), level.Binary, level.Stats)
+			break
+		}
 		level.Body, err = t.Contents()
 		if err != nil {
 			level.Error = err
 			break
 		}
-		_, ok := nav.Changes[lvlPath[1:]]
-		if !ok {
+		// Binary files the patch didn't touch, or only renamed, and text
+		// images (SVG) are shown whole instead of as a diff.
+		isBinary := strings.IndexByte(level.Body, 0) != -1
+		if isBinary || imageMIMEType(t.Name()) != "" {
+			old, new := level.Body, level.Body
+			if level.Stats != nil {
+				old, new = splitDiff(level.Body)
+			}
+			contents := &BinaryContents{Old: []byte(old), New: []byte(new)}
+			level.Image = makeTplImage(t.Name(), contents, level.Stats)
+			if isBinary {
+				level.Binary = contents
+				level.Body = ""
+				break
+			}
+		}
+		if level.Stats != nil && strings.HasSuffix(t.Name(), ".go") {
+			// Files that don't parse just have no outline.
+			_, level.Outline, _ = goOutline(level.Body)
+		}
+		if level.Stats == nil {
 			padded := ""
-			for _, line := range strings.Split(level.Body, "\n") {
+			for _, line := range splitLines(level.Body) {
 				padded += "  " + line + "\n"
 			}
 			level.Body = padded
@@ -160,4 +521,37 @@ func (nav *Navigator) makeTplLevel(
 	return
 }
 
+type levelEntries struct {
+	entries []tplTreeDataLevelEntry
+	less    func(a, b *tplTreeDataLevelEntry) bool
+}
+
+func (s levelEntries) Len() int           { return len(s.entries) }
+func (s levelEntries) Swap(i, j int)      { s.entries[i], s.entries[j] = s.entries[j], s.entries[i] }
+func (s levelEntries) Less(i, j int) bool { return s.less(&s.entries[i], &s.entries[j]) }
+
+// folderChain returns folder followed by the folders below it that are the
+// only entry shown in their parent folder.
+func (nav *Navigator) folderChain(path string, folder *TreeFolder, opts viewOptions) []TreeEntry {
+	chain := []TreeEntry{folder}
+	for {
+		var only TreeEntry
+		shown := 0
+		for _, e := range folder.Entries {
+			if opts.ChangedOnly && nav.Changes[path+"/"+e.Name()] == nil {
+				continue
+			}
+			only = e
+			shown++
+		}
+		sub, ok := only.(*TreeFolder)
+		if shown != 1 || !ok {
+			return chain
+		}
+		path += "/" + sub.Name()
+		folder = sub
+		chain = append(chain, sub)
+	}
+}
+
 var errBadPath = errors.New("bad path.")
@@ -0,0 +1,168 @@
+package navpatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestNoChangedFiles(c *C) {
+	nav := testNavigator(c, testTree, "")
+	c.Assert(nav.ChangedFiles(), DeepEquals, []string{})
+
+	w := serveTest(nav, "/")
+	c.Assert(w.Body.String(), Matches, `(?s).*var changedFiles = \[\];.*`)
+}
+
+// deepTree is testTree with a deep/a/b/c.txt and a deep/a/e.txt.
+func deepTree() *TreeFolder {
+	file := func(name string) *TreeFile {
+		return NewTreeFile(name, func() (string, error) {
+			return name + "\n", nil
+		})
+	}
+	b := NewTreeFolder("b")
+	b.Entries = []TreeEntry{file("c.txt")}
+	a := NewTreeFolder("a")
+	a.Entries = []TreeEntry{b, file("e.txt")}
+	deep := NewTreeFolder("deep")
+	deep.Entries = []TreeEntry{a}
+
+	tree := testTree()
+	tree.Entries = append(tree.Entries, deep)
+	return tree
+}
+
+const deepPatch = `diff --git a/deep/a/b/c.txt b/deep/a/b/c.txt
+index 1111111..2222222 100644
+--- a/deep/a/b/c.txt
++++ b/deep/a/b/c.txt
+@@ -1 +1 @@
+-c.txt
++C.txt
+`
+
+func levelNames(level tplTreeDataLevel) []string {
+	var names []string
+	for _, e := range level.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func (s *PatchS) TestChangedOnlyAndCompact(c *C) {
+	nav := testNavigator(c, deepTree, deepPatch)
+
+	for _, t := range []struct {
+		path  string
+		opts  viewOptions
+		names []string
+	}{
+		{"/", viewOptions{}, []string{"foo", "README", "deep"}},
+		{"/", viewOptions{Compact: true}, []string{"foo", "README", "deep/a"}},
+		{"/", viewOptions{ChangedOnly: true}, []string{"deep"}},
+		// Only changed entries count to make chains.
+		{"/", viewOptions{ChangedOnly: true, Compact: true}, []string{"deep/a/b"}},
+		// The open entry is kept even if it's unchanged.
+		{"/README", viewOptions{ChangedOnly: true}, []string{"README", "deep"}},
+	} {
+		levels, err := nav.makeTplLevels(t.path, t.opts)
+		c.Assert(err, IsNil)
+		c.Assert(levelNames(levels[0]), DeepEquals, t.names, Commentf("%s %+v", t.path, t.opts))
+	}
+
+	// An open chain opens all of its folders at once.
+	levels, err := nav.makeTplLevels("/deep/a/b/c.txt", viewOptions{ChangedOnly: true, Compact: true})
+	c.Assert(err, IsNil)
+	c.Assert(levels, HasLen, 3)
+	c.Assert(levels[0].Entries[0].IsOpen, Equals, true)
+	c.Assert(levels[1].Path, Equals, "/deep/a/b")
+	c.Assert(levelNames(levels[1]), DeepEquals, []string{"c.txt"})
+	c.Assert(levels[2].Path, Equals, "/deep/a/b/c.txt")
+	c.Assert(levels[2].Stats, NotNil)
+
+	// A path into the middle of a chain opens as much of it as it has.
+	levels, err = nav.makeTplLevels("/deep/a", viewOptions{Compact: true})
+	c.Assert(err, IsNil)
+	c.Assert(levels, HasLen, 2)
+	c.Assert(levels[1].Path, Equals, "/deep/a")
+	c.Assert(levelNames(levels[1]), DeepEquals, []string{"b", "e.txt"})
+}
+
+func (s *PatchS) TestEntryOrders(c *C) {
+	nav := testNavigator(c, deepTree, `diff --git a/foo/a.go b/foo/a.go
+index 1111111..2222222 100644
+--- a/foo/a.go
++++ b/foo/a.go
+@@ -3 +3 @@
+-func A() {}
++func A2() {}
+diff --git a/foo/b.go b/foo/b.go
+index 1111111..2222222 100644
+--- a/foo/b.go
++++ b/foo/b.go
+@@ -3 +3 @@
+-func B() {}
++func B2() {}
+diff --git a/README b/README
+index 1111111..2222222 100644
+--- a/README
++++ b/README
+@@ -1 +1,4 @@
+ hello
++there
++and
++there
+`)
+
+	for _, t := range []struct {
+		order string
+		names []string
+	}{
+		{"", []string{"foo", "README", "deep"}},
+		{"churn", []string{"foo", "README", "deep"}},
+		{"additions", []string{"README", "foo", "deep"}},
+		{"changed", []string{"README", "foo", "deep"}},
+		{"folders", []string{"foo", "deep", "README"}},
+	} {
+		levels, err := nav.makeTplLevels("/", viewOptions{Order: t.order})
+		c.Assert(err, IsNil)
+		c.Assert(levelNames(levels[0]), DeepEquals, t.names, Commentf("order %q", t.order))
+	}
+}
+
+func (s *PatchS) TestEntryOrderCookie(c *C) {
+	for _, t := range []struct {
+		url, cookie, order string
+	}{
+		{"/?order=churn", "", "churn"},
+		{"/", "additions", "additions"},
+		// The query wins over the cookie, even to go back to the default.
+		{"/?order=folders", "additions", "folders"},
+		{"/?order=default", "additions", ""},
+		{"/", "bogus", ""},
+	} {
+		req := httptest.NewRequest("GET", t.url, nil)
+		if t.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: orderCookie, Value: t.cookie})
+		}
+		c.Assert(viewOptionsFromRequest(req).Order, Equals, t.order, Commentf("%s %s", t.url, t.cookie))
+	}
+
+	nav := testNavigator(c, testTree, "")
+
+	w := serveTest(nav, "/?order=churn")
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, HasLen, 1)
+	c.Assert(cookies[0].Name, Equals, orderCookie)
+	c.Assert(cookies[0].Value, Equals, "churn")
+
+	w = serveTest(nav, "/?order=default")
+	cookies = w.Result().Cookies()
+	c.Assert(cookies, HasLen, 1)
+	c.Assert(cookies[0].MaxAge, Equals, -1)
+
+	w = serveTest(nav, "/")
+	c.Assert(w.Result().Cookies(), HasLen, 0)
+}
@@ -0,0 +1,271 @@
+package navpatch
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"strings"
+
+	"github.com/aryann/difflib"
+)
+
+// A declChange is a top-level declaration of a Go file that a patch added,
+// removed or modified.
+type declChange struct {
+	// func, method, type, const or var.
+	Kind string
+	// Methods are named like T.Name.
+	Name string
+	// added, removed or modified.
+	Change string
+	// The line anchor of the declaration's first changed line, like L12 or
+	// R12.
+	Anchor string
+	// The name of the file, in package outlines.
+	File string
+}
+
+// A packageOutline holds the declaration changes of the changed files of a
+// package in a folder.
+type packageOutline struct {
+	Name    string
+	Changes []declChange
+}
+
+var (
+	declKinds   = []string{"func", "method", "type", "const", "var"}
+	declChanges = []string{"modified", "added", "removed"}
+)
+
+// Summary counts the package's changes, like "3 funcs changed, 1 type
+// added".
+func (p packageOutline) Summary() string {
+	var parts []string
+	for _, change := range declChanges {
+		for _, kind := range declKinds {
+			n := 0
+			for _, c := range p.Changes {
+				if c.Kind == kind && c.Change == change {
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			noun, verb := kind, change
+			if n > 1 {
+				noun += "s"
+			}
+			if verb == "modified" {
+				verb = "changed"
+			}
+			parts = append(parts, fmt.Sprintf("%d %s %s", n, noun, verb))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type goDecl struct {
+	kind, name string
+	// Source text, to tell whether it changed.
+	text          string
+	line, endLine int
+}
+
+// goDecls returns the top-level declarations of a Go source file by kind and
+// name, in order.
+func goDecls(src string) (pkg string, decls map[string]goDecl, keys []string, err error) {
+	decls = map[string]goDecl{}
+	if strings.TrimSpace(src) == "" {
+		return "", decls, nil, nil
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	add := func(kind, name string, node ast.Node) {
+		if name == "_" {
+			return
+		}
+		start, end := fset.Position(node.Pos()), fset.Position(node.End())
+		key := kind + " " + name
+		// Functions like init can be declared many times.
+		for n := 2; decls[key].kind != ""; n++ {
+			key = fmt.Sprintf("%s %s#%d", kind, name, n)
+		}
+		decls[key] = goDecl{kind, name, src[start.Offset:end.Offset], start.Line, end.Line}
+		keys = append(keys, key)
+	}
+
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add("func", d.Name.Name, d)
+				break
+			}
+			add("method", receiverName(d.Recv.List[0].Type)+"."+d.Name.Name, d)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add("type", s.Name.Name, s)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						add(d.Tok.String(), name.Name, s)
+					}
+				}
+			}
+		}
+	}
+
+	return f.Name.Name, decls, keys, nil
+}
+
+// receiverName returns the name of a method's receiver type, without
+// pointers or type parameters.
+func receiverName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return "?"
+		}
+	}
+}
+
+// goOutline returns the package name and the declaration changes of a diff
+// of a Go file, as rendered by TreeFile.Contents. Added and modified
+// declarations come first, in the order of the new file, then removed ones.
+func goOutline(diff string) (pkg string, changes []declChange, err error) {
+	records := parseDiff(diff)
+	old, new := splitDiff(diff)
+
+	oldPkg, oldDecls, oldKeys, err := goDecls(old)
+	if err != nil {
+		return "", nil, err
+	}
+	pkg, newDecls, newKeys, err := goDecls(new)
+	if err != nil {
+		return "", nil, err
+	}
+	if pkg == "" {
+		pkg = oldPkg
+	}
+
+	// Changed lines by their number in the old and new files.
+	oldChanged, newChanged := map[int]bool{}, map[int]bool{}
+	oldNum, newNum := 0, 0
+	for _, rec := range records {
+		if rec.Delta != difflib.RightOnly {
+			oldNum++
+		}
+		if rec.Delta != difflib.LeftOnly {
+			newNum++
+		}
+		switch rec.Delta {
+		case difflib.LeftOnly:
+			oldChanged[oldNum] = true
+		case difflib.RightOnly:
+			newChanged[newNum] = true
+		}
+	}
+	firstChanged := func(changed map[int]bool, d goDecl) (int, bool) {
+		for n := d.line; n <= d.endLine; n++ {
+			if changed[n] {
+				return n, true
+			}
+		}
+		return d.line, false
+	}
+
+	for _, key := range newKeys {
+		d := newDecls[key]
+		c := declChange{Kind: d.kind, Name: d.name}
+		oldD, existed := oldDecls[key]
+		switch {
+		case !existed:
+			c.Change = "added"
+			n, _ := firstChanged(newChanged, d)
+			c.Anchor = fmt.Sprintf("R%d", n)
+		case oldD.text != d.text:
+			c.Change = "modified"
+			if n, ok := firstChanged(newChanged, d); ok {
+				c.Anchor = fmt.Sprintf("R%d", n)
+			} else if n, ok := firstChanged(oldChanged, oldD); ok {
+				c.Anchor = fmt.Sprintf("L%d", n)
+			} else {
+				c.Anchor = fmt.Sprintf("R%d", d.line)
+			}
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+
+	for _, key := range oldKeys {
+		if _, ok := newDecls[key]; ok {
+			continue
+		}
+		d := oldDecls[key]
+		n, _ := firstChanged(oldChanged, d)
+		changes = append(changes, declChange{
+			Kind:   d.kind,
+			Name:   d.name,
+			Change: "removed",
+			Anchor: fmt.Sprintf("L%d", n),
+		})
+	}
+
+	return pkg, changes, nil
+}
+
+// packageOutlines returns the declaration changes of the Go files in a folder
+// by package, in the order the packages are first found.
+func (nav *Navigator) packageOutlines(lvlPath string, dir *TreeFolder) []packageOutline {
+	var ret []packageOutline
+	for _, entry := range dir.Entries {
+		f, ok := entry.(*TreeFile)
+		entryPath := (lvlPath + "/" + entry.Name())[1:]
+		if !ok || !strings.HasSuffix(f.Name(), ".go") || nav.Changes[entryPath] == nil || f.IsBinary() {
+			continue
+		}
+
+		contents, err := f.Contents()
+		if err != nil {
+			log.Println(entryPath, err)
+			continue
+		}
+		pkg, changes, err := goOutline(contents)
+		if err != nil || len(changes) == 0 {
+			continue
+		}
+		for i := range changes {
+			changes[i].File = f.Name()
+		}
+
+		i := 0
+		for i < len(ret) && ret[i].Name != pkg {
+			i++
+		}
+		if i == len(ret) {
+			ret = append(ret, packageOutline{Name: pkg})
+		}
+		ret[i].Changes = append(ret[i].Changes, changes...)
+	}
+	return ret
+}
@@ -0,0 +1,56 @@
+package navpatch
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestGoOutline(c *C) {
+	diff := "  package p\n" +
+		"  \n" +
+		"- func A() {}\n" +
+		"+ func A() { x() }\n" +
+		"  \n" +
+		"- func B() {}\n" +
+		"- \n" +
+		"  type T struct{}\n" +
+		"  \n" +
+		"- func (t *T) M() {}\n" +
+		"+ func (t *T) M() { return }\n" +
+		"+ \n" +
+		"+ func (g *G[K]) N() {}\n" +
+		"+ \n" +
+		"+ const X, Y = 1, 2\n"
+
+	pkg, changes, err := goOutline(diff)
+	c.Assert(err, IsNil)
+	c.Assert(pkg, Equals, "p")
+	c.Assert(changes, DeepEquals, []declChange{
+		{Kind: "func", Name: "A", Change: "modified", Anchor: "R3"},
+		{Kind: "method", Name: "T.M", Change: "modified", Anchor: "R7"},
+		{Kind: "method", Name: "G.N", Change: "added", Anchor: "R9"},
+		{Kind: "const", Name: "X", Change: "added", Anchor: "R11"},
+		{Kind: "const", Name: "Y", Change: "added", Anchor: "R11"},
+		{Kind: "func", Name: "B", Change: "removed", Anchor: "L5"},
+	})
+
+	c.Assert(packageOutline{Changes: changes}.Summary(), Equals,
+		"1 func changed, 1 method changed, 1 method added, 2 consts added, 1 func removed")
+
+	// Files that don't parse have no outline.
+	_, _, err = goOutline("+ package p\n+ func {\n")
+	c.Assert(err, NotNil)
+}
+
+func (s *PatchS) TestPackageOutlines(c *C) {
+	nav := testNavigator(c, testTree, searchPatch)
+	levels, err := nav.makeTplLevels("/foo", viewOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(levels, HasLen, 2)
+	c.Assert(levels[1].Packages, DeepEquals, []packageOutline{{
+		Name: "foo",
+		Changes: []declChange{
+			{Kind: "func", Name: "A2", Change: "added", Anchor: "R3", File: "a.go"},
+			{Kind: "func", Name: "A", Change: "removed", Anchor: "L3", File: "a.go"},
+		},
+	}})
+}
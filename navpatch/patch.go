@@ -1,7 +1,9 @@
 package navpatch
 
 import (
+	"bytes"
 	"strings"
+	"unicode"
 
 	"github.com/aryann/difflib"
 	"golang.org/x/codereview/patch"
@@ -12,45 +14,179 @@ type DiffStats struct {
 	Deletions int
 	Added     bool
 	Removed   bool
+	Renamed   bool
+	Copied    bool
+	OldPath   string
 	OldMode   int
 	NewMode   int
+	Binary    bool
+	OldSize   int
+	NewSize   int
 	Chunks    patch.TextDiff
+	// Lines moved in and out, which aren't counted as additions and
+	// deletions, and the blocks they are in. Folders only have the counts.
+	MovedIn  int
+	MovedOut int
+	Moves    []MovedBlock
+	// Generated and vendored files don't count in their folders' totals, but
+	// in their GeneratedAdditions and GeneratedDeletions.
+	Generated          bool
+	Vendored           bool
+	GeneratedAdditions int
+	GeneratedDeletions int
+}
+
+const (
+	modeTypeMask = 0170000
+	modeSymlink  = 0120000
+	modeExecMask = 0111
+)
+
+// Mode returns the mode of the file after the patch or, for removed files,
+// before it. It is 0 if the patch didn't tell.
+func (s DiffStats) Mode() int {
+	if s.NewMode != 0 {
+		return s.NewMode
+	}
+	return s.OldMode
+}
+
+// ModeChanged reports whether the patch changes the mode of an existing file.
+func (s DiffStats) ModeChanged() bool {
+	return s.OldMode != 0 && s.NewMode != 0 && s.OldMode != s.NewMode
+}
+
+// Moved counts the lines moved in and out.
+func (s DiffStats) Moved() int {
+	return s.MovedIn + s.MovedOut
+}
+
+func (s DiffStats) IsExecutable() bool {
+	return s.Mode()&modeTypeMask != modeSymlink && s.Mode()&modeExecMask != 0
+}
+
+func (s DiffStats) IsSymlink() bool {
+	return s.Mode()&modeTypeMask == modeSymlink
 }
 
 func ApplyChangesToTree(patchSet *patch.Set, tree TreeEntry) map[string]*DiffStats {
+	return ApplyChangesToTreeIgnoring(patchSet, tree, WhitespaceExact)
+}
+
+// ApplyChangesToTreeIgnoring is like ApplyChangesToTree, but lines that only
+// differ in the whitespace that ws ignores are taken as unchanged, both in
+// the files' diffs and in their stats.
+func ApplyChangesToTreeIgnoring(patchSet *patch.Set, tree TreeEntry, ws Whitespace) map[string]*DiffStats {
 	changes := map[string]*DiffStats{}
 
+	// Renames and copies take their contents from the source file as it was
+	// before the patch, so detach all of them before anything else touches
+	// the tree. This also gets swaps (a -> b, b -> a) right.
+	sources := map[*patch.File]ContentRetriever{}
+	for _, pf := range patchSet.File {
+		if pf.Verb == patch.Rename || pf.Verb == patch.Copy {
+			src := detachFileFromTree(strings.Split(pf.Src, "/"), tree, pf.Verb == patch.Rename)
+			if src != nil {
+				sources[pf] = src.contents
+			}
+		}
+	}
+
 	for _, pf := range patchSet.File {
 		var stats *DiffStats
-		diff, ok := pf.Diff.(patch.TextDiff)
-		if !ok {
-			// TODO: Git binary diffs.
+		var diff patch.TextDiff
+		switch d := pf.Diff.(type) {
+		case patch.TextDiff:
+			diff = d
+		case *binaryDiff:
+			applyBinaryDiff(pf, d, tree, sources[pf], changes)
 			continue
+		case *patch.GitBinaryLiteral:
+			d2 := &binaryDiff{forward: &binaryHunk{size: len(d.New), data: d.New}}
+			applyBinaryDiff(pf, d2, tree, sources[pf], changes)
+			continue
+		default:
+			if pf.Diff != patch.NoDiff {
+				continue
+			}
+			// Pure renames, copies and mode changes come without content
+			// changes.
+			diff = patch.TextDiff{}
 		}
 
 		switch pf.Verb {
 		case patch.Add:
-			stats = statsFromDiff(diff)
+			stats = statsFromDiff(diff, ws)
 			stats.Added = true
 			changes[pf.Dst] = stats
-			addFileToTree(strings.Split(pf.Dst, "/"), tree, diff)
+			addFileToTree(strings.Split(pf.Dst, "/"), tree, diff, ws)
 		case patch.Edit:
-			stats = statsFromDiff(diff)
+			stats = statsFromDiff(diff, ws)
 			changes[pf.Dst] = stats
-			editFileInTree(strings.Split(pf.Dst, "/"), tree, diff)
+			editFileInTree(strings.Split(pf.Dst, "/"), tree, diff, ws)
 		case patch.Delete:
-			stats = statsFromDiff(diff)
+			stats = statsFromDiff(diff, ws)
 			stats.Removed = true
 			changes[pf.Src] = stats
-			editFileInTree(strings.Split(pf.Src, "/"), tree, diff)
+			editFileInTree(strings.Split(pf.Src, "/"), tree, diff, ws)
+		case patch.Rename, patch.Copy:
+			stats = statsFromDiff(diff, ws)
+			stats.Renamed = pf.Verb == patch.Rename
+			stats.Copied = pf.Verb == patch.Copy
+			stats.OldPath = pf.Src
+			changes[pf.Dst] = stats
+			if src := sources[pf]; src != nil {
+				moveFileInTree(strings.Split(pf.Dst, "/"), tree, src, diff, ws)
+			}
+		}
+
+		if stats != nil {
+			setModes(stats, pf)
 		}
 	}
 
+	detectMoves(changes, ws)
+	markGenerated(tree, changes)
 	addFoldersToChanges(changes)
 
 	return changes
 }
 
+func applyBinaryDiff(pf *patch.File, diff *binaryDiff, tree TreeEntry, src ContentRetriever, changes map[string]*DiffStats) {
+	stats := &DiffStats{Binary: true}
+	stats.OldSize, stats.NewSize = diff.sizes()
+	setModes(stats, pf)
+
+	switch pf.Verb {
+	case patch.Add:
+		stats.Added = true
+		stats.OldSize = 0
+		changes[pf.Dst] = stats
+		addBinaryFileToTree(strings.Split(pf.Dst, "/"), tree, nil, diff)
+	case patch.Edit:
+		changes[pf.Dst] = stats
+		editBinaryFileInTree(strings.Split(pf.Dst, "/"), tree, diff, false)
+	case patch.Delete:
+		stats.Removed = true
+		stats.NewSize = 0
+		changes[pf.Src] = stats
+		editBinaryFileInTree(strings.Split(pf.Src, "/"), tree, diff, true)
+	case patch.Rename, patch.Copy:
+		stats.Renamed = pf.Verb == patch.Rename
+		stats.Copied = pf.Verb == patch.Copy
+		stats.OldPath = pf.Src
+		changes[pf.Dst] = stats
+		if src != nil {
+			addBinaryFileToTree(strings.Split(pf.Dst, "/"), tree, src, diff)
+		}
+	}
+}
+
+func setModes(stats *DiffStats, pf *patch.File) {
+	stats.OldMode = pf.OldMode
+	stats.NewMode = pf.NewMode
+}
+
 func addFoldersToChanges(changes map[string]*DiffStats) {
 	ks := []string{}
 	for k, _ := range changes {
@@ -67,15 +203,22 @@ func addFoldersToChanges(changes map[string]*DiffStats) {
 				changes[folder] = prevDiff
 			}
 
+			if diff.Generated || diff.Vendored {
+				prevDiff.GeneratedAdditions += diff.Additions
+				prevDiff.GeneratedDeletions += diff.Deletions
+				continue
+			}
 			prevDiff.Additions += diff.Additions
 			prevDiff.Deletions += diff.Deletions
+			prevDiff.MovedIn += diff.MovedIn
+			prevDiff.MovedOut += diff.MovedOut
 		}
 	}
 }
 
-func addFileToTree(path []string, tree TreeEntry, diff patch.TextDiff) {
+func addFileToTree(path []string, tree TreeEntry, diff patch.TextDiff, ws Whitespace) {
 	changeFileInTree(path, tree, func(folder *TreeFolder) {
-		ret, err := applyPatch(diff, "")
+		ret, err := applyPatch(diff, "", ws)
 		entry := NewTreeFile(path[len(path)-1], func() (string, error) {
 			return ret, err
 		})
@@ -84,7 +227,7 @@ func addFileToTree(path []string, tree TreeEntry, diff patch.TextDiff) {
 	})
 }
 
-func editFileInTree(path []string, tree TreeEntry, diff patch.TextDiff) {
+func editFileInTree(path []string, tree TreeEntry, diff patch.TextDiff, ws Whitespace) {
 	changeFileInTree(path, tree, func(folder *TreeFolder) {
 		for _, entry := range folder.Entries {
 			if entry.Name() == path[len(path)-1] {
@@ -99,12 +242,112 @@ func editFileInTree(path []string, tree TreeEntry, diff patch.TextDiff) {
 					if err != nil {
 						return "", err
 					}
-					return applyPatch(diff, prev)
+					return applyPatch(diff, prev, ws)
+				}
+				break
+			}
+		}
+	})
+}
+
+// addBinaryFileToTree puts at path a new binary file whose contents are
+// those retrieved by src, if any, with diff applied.
+func addBinaryFileToTree(path []string, tree TreeEntry, src ContentRetriever, diff *binaryDiff) {
+	changeFileInTree(path, tree, func(folder *TreeFolder) {
+		entry := &TreeFile{name: path[len(path)-1]}
+		entry.binary = binaryContentsRetriever(src, diff, false)
+		entry.contents = binaryNewContents(entry)
+
+		folder.Entries = append(folder.Entries, entry)
+	})
+}
+
+func editBinaryFileInTree(path []string, tree TreeEntry, diff *binaryDiff, removed bool) {
+	changeFileInTree(path, tree, func(folder *TreeFolder) {
+		for _, entry := range folder.Entries {
+			if entry.Name() == path[len(path)-1] {
+				entryFile, ok := entry.(*TreeFile)
+				if !ok {
+					return
+				}
+				entryFile.binary = binaryContentsRetriever(entryFile.contents, diff, removed)
+				entryFile.contents = binaryNewContents(entryFile)
+				break
+			}
+		}
+	})
+}
+
+func binaryContentsRetriever(src ContentRetriever, diff *binaryDiff, removed bool) BinaryContentRetriever {
+	return func() (*BinaryContents, error) {
+		ret := &BinaryContents{}
+		if src != nil {
+			prev, err := src()
+			if err != nil {
+				return nil, err
+			}
+			ret.Old = []byte(prev)
+		}
+		if removed {
+			return ret, nil
+		}
+
+		var err error
+		ret.New, err = diff.Apply(ret.Old)
+		if err == errNoBinaryData {
+			err = nil
+		}
+		return ret, err
+	}
+}
+
+// binaryNewContents retrieves the contents of a binary file after the patch.
+func binaryNewContents(f *TreeFile) ContentRetriever {
+	return func() (string, error) {
+		bc, err := f.BinaryContents()
+		if err != nil {
+			return "", err
+		}
+		return string(bc.New), nil
+	}
+}
+
+// detachFileFromTree returns the file at path, removing it from its folder if
+// remove is true.
+func detachFileFromTree(path []string, tree TreeEntry, remove bool) *TreeFile {
+	var ret *TreeFile
+	changeFileInTree(path, tree, func(folder *TreeFolder) {
+		for i, entry := range folder.Entries {
+			if entry.Name() == path[len(path)-1] {
+				entryFile, ok := entry.(*TreeFile)
+				if !ok {
+					return
+				}
+				ret = entryFile
+				if remove {
+					folder.Entries = append(folder.Entries[:i], folder.Entries[i+1:]...)
 				}
 				break
 			}
 		}
 	})
+	return ret
+}
+
+// moveFileInTree puts at path a new file whose contents are those retrieved
+// by src with diff applied.
+func moveFileInTree(path []string, tree TreeEntry, src ContentRetriever, diff patch.TextDiff, ws Whitespace) {
+	changeFileInTree(path, tree, func(folder *TreeFolder) {
+		entry := NewTreeFile(path[len(path)-1], func() (string, error) {
+			prev, err := src()
+			if err != nil {
+				return "", err
+			}
+			return applyPatch(diff, prev, ws)
+		})
+
+		folder.Entries = append(folder.Entries, entry)
+	})
 }
 
 func changeFileInTree(path []string, tree TreeEntry, changeCallback func(*TreeFolder)) {
@@ -126,7 +369,8 @@ func changeFileInTree(path []string, tree TreeEntry, changeCallback func(*TreeFo
 			}
 
 			if entry == nil {
-				t.Entries = append(t.Entries, NewTreeFolder(name))
+				entry = NewTreeFolder(name)
+				t.Entries = append(t.Entries, entry)
 			}
 
 			changeFileInTree(path[1:], entry, changeCallback)
@@ -138,13 +382,14 @@ func changeFileInTree(path []string, tree TreeEntry, changeCallback func(*TreeFo
 	}
 }
 
-func statsFromDiff(diff patch.TextDiff) *DiffStats {
+func statsFromDiff(diff patch.TextDiff, ws Whitespace) *DiffStats {
 	stats := DiffStats{Chunks: diff}
 
 	for _, chunk := range diff {
-		atoms := difflib.Diff(
+		atoms := diffLines(
 			strings.Split(string(chunk.Old), "\n"),
 			strings.Split(string(chunk.New), "\n"),
+			ws,
 		)
 		for _, a := range atoms {
 			if a.Delta == difflib.LeftOnly {
@@ -158,13 +403,13 @@ func statsFromDiff(diff patch.TextDiff) *DiffStats {
 	return &stats
 }
 
-func applyPatch(diff patch.TextDiff, prev string) (string, error) {
+func applyPatch(diff patch.TextDiff, prev string, ws Whitespace) (string, error) {
 	curr, err := diff.Apply([]byte(prev))
 	if err != nil {
 		return "", err
 	}
 
-	chunks := difflib.Diff(strings.Split(prev, "\n"), strings.Split(string(curr), "\n"))
+	chunks := diffLines(splitLines(prev), splitLines(string(curr)), ws)
 	ret := ""
 
 	for _, ch := range chunks {
@@ -173,3 +418,123 @@ func applyPatch(diff patch.TextDiff, prev string) (string, error) {
 
 	return ret, nil
 }
+
+// splitLines splits text in lines. A newline at the end of text ends its
+// last line instead of starting an empty one.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Whitespace tells which changes in whitespace are ignored when diffing
+// lines.
+type Whitespace int
+
+const (
+	// WhitespaceExact ignores no change.
+	WhitespaceExact Whitespace = iota
+	// IgnoreSpaceChange ignores changes in the amount of whitespace and
+	// whitespace at the end of lines, like git diff -b.
+	IgnoreSpaceChange
+	// IgnoreAllSpace ignores all whitespace, like git diff -w.
+	IgnoreAllSpace
+)
+
+// key returns what is compared of line.
+func (ws Whitespace) key(line string) string {
+	if ws == WhitespaceExact {
+		return line
+	}
+
+	var buf bytes.Buffer
+	inSpace := false
+	for _, r := range line {
+		if !unicode.IsSpace(r) {
+			inSpace = false
+			buf.WriteRune(r)
+			continue
+		}
+		if !inSpace && ws == IgnoreSpaceChange {
+			buf.WriteByte(' ')
+		}
+		inSpace = true
+	}
+	return strings.TrimRight(buf.String(), " ")
+}
+
+// diffLines diffs two lists of lines, ignoring the whitespace that ws
+// ignores. Common lines have the payload of the new line.
+func diffLines(old, new []string, ws Whitespace) []difflib.DiffRecord {
+	if ws == WhitespaceExact {
+		return difflib.Diff(old, new)
+	}
+
+	oldKeys, newKeys := make([]string, len(old)), make([]string, len(new))
+	for i, line := range old {
+		oldKeys[i] = ws.key(line)
+	}
+	for i, line := range new {
+		newKeys[i] = ws.key(line)
+	}
+
+	records := difflib.Diff(oldKeys, newKeys)
+	i, j := 0, 0
+	for k := range records {
+		switch records[k].Delta {
+		case difflib.LeftOnly:
+			records[k].Payload = old[i]
+			i++
+		case difflib.RightOnly:
+			records[k].Payload = new[j]
+			j++
+		default:
+			records[k].Payload = new[j]
+			i++
+			j++
+		}
+	}
+	return records
+}
+
+// parseDiff takes a diff as rendered by applyPatch and returns its records.
+func parseDiff(diff string) []difflib.DiffRecord {
+	var ret []difflib.DiffRecord
+	for _, line := range strings.Split(diff, "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		rec := difflib.DiffRecord{Payload: line[2:], Delta: difflib.Common}
+		switch line[:2] {
+		case "+ ":
+			rec.Delta = difflib.RightOnly
+		case "- ":
+			rec.Delta = difflib.LeftOnly
+		}
+		ret = append(ret, rec)
+	}
+	return ret
+}
+
+// splitDiff takes a diff as rendered by applyPatch and returns the file
+// contents before and after the patch, with each line ended by a newline.
+func splitDiff(diff string) (old, new string) {
+	var oldLines, newLines []string
+	for _, rec := range parseDiff(diff) {
+		if rec.Delta != difflib.RightOnly {
+			oldLines = append(oldLines, rec.Payload)
+		}
+		if rec.Delta != difflib.LeftOnly {
+			newLines = append(newLines, rec.Payload)
+		}
+	}
+	return joinLines(oldLines), joinLines(newLines)
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,410 @@
+package navpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type PatchS struct{}
+
+var _ = Suite(&PatchS{})
+
+func testTree() *TreeFolder {
+	file := func(name, contents string) *TreeFile {
+		return NewTreeFile(name, func() (string, error) {
+			return contents, nil
+		})
+	}
+
+	root := NewTreeFolder(".")
+	foo := NewTreeFolder("foo")
+	foo.Entries = []TreeEntry{
+		file("a.go", "package foo\n\nfunc A() {}\n"),
+		file("b.go", "package foo\n\nfunc B() {}\n"),
+	}
+	root.Entries = []TreeEntry{foo, file("README", "hello\n")}
+	return root
+}
+
+func applyTestPatch(c *C, raw string) (*TreeFolder, map[string]*DiffStats) {
+	set, err := ParsePatch([]byte(raw))
+	c.Assert(err, IsNil)
+	tree := testTree()
+	return tree, ApplyChangesToTree(set, tree)
+}
+
+// testRepo is a repository whose tree is made anew by calling it, as
+// navigators change the trees they are given.
+type testRepo func() *TreeFolder
+
+func (r testRepo) Tree() (TreeEntry, error) {
+	return r(), nil
+}
+
+func testNavigator(c *C, tree func() *TreeFolder, raw string) *Navigator {
+	nav, err := NewNavigator(testRepo(tree), []byte(raw))
+	c.Assert(err, IsNil)
+	return nav
+}
+
+// serveTest serves a GET request for url with nav.
+func serveTest(nav *Navigator, url string, headers ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	nav.ServeHTTP(w, req)
+	return w
+}
+
+// numberedLines returns n lines like "line 1".
+func numberedLines(n int) string {
+	ret := ""
+	for i := 1; i <= n; i++ {
+		ret += fmt.Sprintf("line %d\n", i)
+	}
+	return ret
+}
+
+func (s *PatchS) TestRename(c *C) {
+	tree, changes := applyTestPatch(c, "diff --git a/foo/a.go b/bar/a.go\n"+
+		"similarity index 80%\n"+
+		"rename from foo/a.go\n"+
+		"rename to bar/a.go\n"+
+		"index 1111111..2222222 100644\n"+
+		"--- a/foo/a.go\n"+
+		"+++ b/bar/a.go\n"+
+		"@@ -1,3 +1,3 @@\n"+
+		"-package foo\n"+
+		"+package bar\n"+
+		" \n"+
+		" func A() {}\n")
+
+	c.Assert(tree.String(), Equals, `.
+-- foo
+-- -- b.go
+-- README
+-- bar
+-- -- a.go
+`)
+
+	stats := changes["bar/a.go"]
+	c.Assert(stats, NotNil)
+	c.Assert(stats.Renamed, Equals, true)
+	c.Assert(stats.OldPath, Equals, "foo/a.go")
+	c.Assert(stats.Additions, Equals, 1)
+	c.Assert(stats.Deletions, Equals, 1)
+	c.Assert(changes["bar"].Additions, Equals, 1)
+
+	body, err := tree.Entries[2].(*TreeFolder).Entries[0].(*TreeFile).Contents()
+	c.Assert(err, IsNil)
+	c.Assert(body, Equals, "- package foo\n+ package bar\n  \n  func A() {}\n")
+}
+
+func (s *PatchS) TestPureCopy(c *C) {
+	tree, changes := applyTestPatch(c, `diff --git a/README b/foo/README
+similarity index 100%
+copy from README
+copy to foo/README
+`)
+
+	c.Assert(tree.String(), Equals, `.
+-- foo
+-- -- a.go
+-- -- b.go
+-- -- README
+-- README
+`)
+
+	stats := changes["foo/README"]
+	c.Assert(stats, NotNil)
+	c.Assert(stats.Copied, Equals, true)
+	c.Assert(stats.OldPath, Equals, "README")
+	c.Assert(stats.Additions, Equals, 0)
+	c.Assert(stats.Deletions, Equals, 0)
+}
+
+func (s *PatchS) TestModeOnlyChange(c *C) {
+	_, changes := applyTestPatch(c, `diff --git a/README b/README
+old mode 100644
+new mode 100755
+`)
+
+	stats := changes["README"]
+	c.Assert(stats, NotNil)
+	c.Assert(stats.OldMode, Equals, 0100644)
+	c.Assert(stats.NewMode, Equals, 0100755)
+	c.Assert(stats.ModeChanged(), Equals, true)
+	c.Assert(stats.IsExecutable(), Equals, true)
+	c.Assert(stats.IsSymlink(), Equals, false)
+	c.Assert(stats.Additions, Equals, 0)
+	c.Assert(stats.Deletions, Equals, 0)
+}
+
+func (s *PatchS) TestBinary(c *C) {
+	set, err := ParsePatch([]byte(`diff --git a/moved.bin b/moved2.bin
+similarity index 60%
+rename from moved.bin
+rename to moved2.bin
+index fb4ebfa6e27a008b7f396e38d551cd1383c3e2fc..2346ee86f0c17379b2f2b55f149e7fbe2c5fcba9 100644
+GIT binary patch
+delta 6
+Ncmd1Invlt;2mlBy0q+0+
+
+delta 4
+Lcmd1GoRA3s1aJY(
+
+diff --git a/new.bin b/new.bin
+new file mode 100644
+index 0000000000000000000000000000000000000000..8551784288d13f022422ef698b389ce8d3adbe8a
+GIT binary patch
+literal 306
+QcmeAS@N;Jv1tf$30JQu8ZvX%Q
+
+literal 0
+HcmV?d00001
+
+`))
+	c.Assert(err, IsNil)
+
+	old := strings.Repeat("\x00", 100) + "moved"
+	tree := NewTreeFolder(".")
+	tree.Entries = []TreeEntry{NewTreeFile("moved.bin", func() (string, error) {
+		return old, nil
+	})}
+	changes := ApplyChangesToTree(set, tree)
+
+	c.Assert(tree.String(), Equals, `.
+-- moved2.bin
+-- new.bin
+`)
+
+	stats := changes["moved2.bin"]
+	c.Assert(stats.Binary, Equals, true)
+	c.Assert(stats.Renamed, Equals, true)
+	c.Assert(stats.OldSize, Equals, 105)
+	c.Assert(stats.NewSize, Equals, 106)
+
+	moved := tree.Entries[0].(*TreeFile)
+	c.Assert(moved.IsBinary(), Equals, true)
+	bc, err := moved.BinaryContents()
+	c.Assert(err, IsNil)
+	c.Assert(string(bc.Old), Equals, old)
+	c.Assert(string(bc.New), Equals, old+"!")
+
+	stats = changes["new.bin"]
+	c.Assert(stats.Added, Equals, true)
+	c.Assert(stats.OldSize, Equals, 0)
+	c.Assert(stats.NewSize, Equals, 306)
+
+	bc, err = tree.Entries[1].(*TreeFile).BinaryContents()
+	c.Assert(err, IsNil)
+	c.Assert(bc.Old, IsNil)
+	c.Assert(string(bc.New), Equals, "\x89PNG\x00\x00"+strings.Repeat("\x00", 300))
+}
+
+func (s *PatchS) TestMovedBlock(c *C) {
+	set, err := ParsePatch([]byte(`diff --git a/README b/README
+index 1111111..2222222 100644
+--- a/README
++++ b/README
+@@ -1,4 +1,1 @@
+ hello
+-This block of lines
+-is moved to another file
+-and reindented there.
+diff --git a/NOTES b/NOTES
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/NOTES
+@@ -0,0 +1,4 @@
++Notes:
++  This block of lines
++  is moved to another file
++  and reindented there.
+`))
+	c.Assert(err, IsNil)
+
+	tree := NewTreeFolder(".")
+	tree.Entries = []TreeEntry{NewTreeFile("README", func() (string, error) {
+		return "hello\nThis block of lines\nis moved to another file\nand reindented there.\n", nil
+	})}
+	changes := ApplyChangesToTree(set, tree)
+
+	from := changes["README"]
+	c.Assert(from.Deletions, Equals, 0)
+	c.Assert(from.MovedOut, Equals, 3)
+	c.Assert(from.Moves, DeepEquals, []MovedBlock{{Out: true, Line: 2, Count: 3, OtherPath: "NOTES", OtherLine: 2}})
+
+	to := changes["NOTES"]
+	c.Assert(to.Additions, Equals, 1)
+	c.Assert(to.MovedIn, Equals, 3)
+	c.Assert(to.Moves, DeepEquals, []MovedBlock{{Out: false, Line: 2, Count: 3, OtherPath: "README", OtherLine: 2}})
+}
+
+func (s *PatchS) TestIgnoreWhitespace(c *C) {
+	raw := `diff --git a/foo/b.go b/foo/b.go
+index 1111111..2222222 100644
+--- a/foo/b.go
++++ b/foo/b.go
+@@ -1,3 +1,3 @@
+ package foo
+ 
+-func B() {}
++func  B() { }
+`
+	for _, t := range []struct {
+		ws        Whitespace
+		additions int
+		body      string
+	}{
+		{WhitespaceExact, 1, "  package foo\n  \n- func B() {}\n+ func  B() { }\n"},
+		{IgnoreSpaceChange, 1, "  package foo\n  \n- func B() {}\n+ func  B() { }\n"},
+		{IgnoreAllSpace, 0, "  package foo\n  \n  func  B() { }\n"},
+	} {
+		set, err := ParsePatch([]byte(raw))
+		c.Assert(err, IsNil)
+		tree := testTree()
+		changes := ApplyChangesToTreeIgnoring(set, tree, t.ws)
+		c.Assert(changes["foo/b.go"].Additions, Equals, t.additions)
+		c.Assert(changes["foo/b.go"].Deletions, Equals, t.additions)
+
+		body, err := tree.Entries[0].(*TreeFolder).Entries[1].(*TreeFile).Contents()
+		c.Assert(err, IsNil)
+		c.Assert(body, Equals, t.body)
+	}
+}
+
+// generatedPatch changes a generated file, a vendored one and some that
+// only look like them.
+const generatedPatch = `diff --git a/foo/a.go b/foo/a.go
+index 1111111..2222222 100644
+--- a/foo/a.go
++++ b/foo/a.go
+@@ -1,3 +1,4 @@
+ package foo
+ 
+ func A() {}
++func A2() {}
+diff --git a/foo/b.go b/foo/b.go
+index 1111111..2222222 100644
+--- a/foo/b.go
++++ b/foo/b.go
+@@ -1,3 +1,5 @@
++// Code generated by stringer. DO NOT EDIT.
++
+ package foo
+ 
+ func B() {}
+diff --git a/foo/d.go b/foo/d.go
+new file mode 100644
+index 0000000..6666666
+--- /dev/null
++++ b/foo/d.go
+@@ -0,0 +1,5 @@
++package foo
++
++const header = ` + "`" + `
++// Code generated by gen. DO NOT EDIT.
++` + "`" + `
+diff --git a/foo/c.pb.go b/foo/c.pb.go
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/foo/c.pb.go
+@@ -0,0 +1 @@
++package foo
+diff --git a/vendor/lib/l.go b/vendor/lib/l.go
+new file mode 100644
+index 0000000..4444444
+--- /dev/null
++++ b/vendor/lib/l.go
+@@ -0,0 +1 @@
++package lib
+diff --git a/.navpatchattributes b/.navpatchattributes
+new file mode 100644
+index 0000000..5555555
+--- /dev/null
++++ b/.navpatchattributes
+@@ -0,0 +1,2 @@
++# Hand-written, despite the name.
++*.pb.go -generated
+`
+
+func (s *PatchS) TestGeneratedFiles(c *C) {
+	_, changes := applyTestPatch(c, generatedPatch)
+
+	c.Assert(changes["foo/a.go"].Generated, Equals, false)
+	c.Assert(changes["foo/b.go"].Generated, Equals, true)
+	c.Assert(changes["foo/c.pb.go"].Generated, Equals, false)
+	c.Assert(changes["foo/d.go"].Generated, Equals, false)
+	c.Assert(changes["vendor/lib/l.go"].Vendored, Equals, true)
+
+	foo := changes["foo"]
+	c.Assert(foo.Additions, Equals, 7)
+	c.Assert(foo.GeneratedAdditions, Equals, 2)
+
+	vendor := changes["vendor"]
+	c.Assert(vendor.Additions, Equals, 0)
+	c.Assert(vendor.GeneratedAdditions, Equals, 1)
+}
+
+func (s *PatchS) TestLooksGenerated(c *C) {
+	for _, t := range []struct {
+		name, text string
+		top        bool
+		generated  bool
+	}{
+		{"a.go", "// Code generated by gen. DO NOT EDIT.\n\npackage a\n", true, true},
+		{"a.go", "/* License\n */\n\n// Code generated by gen. DO NOT EDIT.\npackage a\n", true, true},
+		{"a.go", "// Code generated by gen. DO NOT EDIT.\n", false, false},
+		{"a.go", "package a\n\n// Code generated by gen. DO NOT EDIT.\n", true, false},
+		{"a.go", "// Code generated by gen. DO NOT EDIT. Or do.\npackage a\n", true, false},
+		{"a.txt", "// Code generated by gen. DO NOT EDIT.\n", true, false},
+		{"a.js", strings.Repeat("x", 3*minifiedLineLength) + "\n", false, true},
+		{"a.js", "var x = 1;\n", true, false},
+	} {
+		c.Check(looksGenerated(t.name, t.text, t.top), Equals, t.generated, Commentf("%s %q", t.name, t.text))
+	}
+}
+
+func (s *PatchS) TestGeneratedToggle(c *C) {
+	nav := testNavigator(c, testTree, generatedPatch)
+
+	c.Assert(serveTest(nav, "/?summary=1").Body.String(), Matches, `(?s).*<span class="additions">\+9</span>.*`)
+	c.Assert(serveTest(nav, "/?summary=1&generated=1").Body.String(), Matches, `(?s).*<span class="additions">\+12</span>.*`)
+
+	fooAdditions := func(url string) int {
+		var folder jsonEntry
+		c.Assert(json.Unmarshal(serveTest(nav, url).Body.Bytes(), &folder), IsNil)
+		for _, e := range folder.Entries {
+			if e.Name == "foo" {
+				return e.Stats.Additions
+			}
+		}
+		c.Fatalf("no foo in %s", url)
+		return 0
+	}
+	c.Assert(fooAdditions("/?format=json"), Equals, 7)
+	c.Assert(fooAdditions("/?format=json&generated=1"), Equals, 9)
+
+	var file jsonEntry
+	c.Assert(json.Unmarshal(serveTest(nav, "/foo/b.go?format=json").Body.Bytes(), &file), IsNil)
+	c.Assert(file.Stats.Generated, Equals, true)
+	c.Assert(file.Hunks, HasLen, 0)
+	c.Assert(json.Unmarshal(serveTest(nav, "/foo/b.go?format=json&generated=1").Body.Bytes(), &file), IsNil)
+	c.Assert(file.Hunks, HasLen, 1)
+
+	c.Assert(serveTest(nav, "/foo/b.go?format=text").Body.String(), Matches, `(?s).*This file is generated;.*`)
+	c.Assert(serveTest(nav, "/foo/b.go?format=text&generated=1").Body.String(), Matches, `(?s).*Code generated by stringer.*`)
+}
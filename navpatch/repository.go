@@ -1,6 +1,9 @@
 package navpatch
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 type Repository interface {
 	Tree() (TreeEntry, error)
@@ -37,10 +40,22 @@ type TreeFile struct {
 	contents       ContentRetriever
 	cached         bool
 	cachedContents string
+	binary         BinaryContentRetriever
+	cachedBinary   *BinaryContents
 }
 
 type ContentRetriever func() (string, error)
 
+// BinaryContents are the contents of a binary file before and after a patch.
+// Old is nil for added files and New is nil for removed files and for files
+// whose new contents weren't included in the patch.
+type BinaryContents struct {
+	Old []byte
+	New []byte
+}
+
+type BinaryContentRetriever func() (*BinaryContents, error)
+
 func NewTreeFile(name string, f ContentRetriever) *TreeFile {
 	return &TreeFile{name: name, contents: f}
 }
@@ -69,6 +84,29 @@ func (f *TreeFile) Contents() (string, error) {
 	return ret, err
 }
 
+// IsBinary reports whether the file was changed by a binary patch.
+func (f *TreeFile) IsBinary() bool {
+	return f.binary != nil
+}
+
+// BinaryContents returns the contents of a file changed by a binary patch.
+func (f *TreeFile) BinaryContents() (*BinaryContents, error) {
+	if f.binary == nil {
+		return nil, errors.New("not a binary file")
+	}
+
+	if f.cachedBinary != nil {
+		return f.cachedBinary, nil
+	}
+
+	ret, err := f.binary()
+	if err == nil {
+		f.cachedBinary = ret
+	}
+
+	return ret, err
+}
+
 func DirTreeString(entry TreeEntry) string {
 	return dirTreeString2(entry, 0)
 }
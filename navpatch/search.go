@@ -0,0 +1,221 @@
+package navpatch
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aryann/difflib"
+)
+
+// Searches stop after this many results.
+const maxSearchResults = 1000
+
+// Search scopes, in the scope query parameter. The default is every file.
+const (
+	searchChangedFiles = "changed"
+	searchChangedLines = "lines"
+)
+
+type tplSearchData struct {
+	Title     string
+	Query     string
+	Regexp    bool
+	Scope     string
+	Error     error
+	Results   []searchResult
+	Truncated bool
+	Max       int
+	pageLinks
+	reqURL *url.URL
+}
+
+func (d *tplSearchData) ParamsExcept(keys ...string) []tplParam {
+	return paramsExcept(d.reqURL, keys...)
+}
+
+type searchResult struct {
+	Path    string
+	Line    int
+	Link    string
+	Snippet template.HTML
+}
+
+// serveSearch serves the lines of the patched tree that match the search
+// query parameter, literally or, with regexp=1, as a regular expression.
+func (nav *Navigator) serveSearch(w http.ResponseWriter, req *http.Request, path, linksPrefix, linksSuffix string) {
+	q := req.URL.Query()
+	data := &tplSearchData{
+		Title:     "navpatch - search: " + q.Get("search"),
+		Query:     q.Get("search"),
+		Regexp:    q.Get("regexp") == "1",
+		Scope:     q.Get("scope"),
+		Max:       maxSearchResults,
+		pageLinks: makePageLinks(path, linksPrefix, linksSuffix, "search", "regexp", "scope"),
+		reqURL:    req.URL,
+	}
+
+	expr := data.Query
+	if !data.Regexp {
+		expr = regexp.QuoteMeta(expr)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		data.Error = err
+	} else if data.Query != "" {
+		data.Results, data.Truncated = nav.search(re, data.Scope, maxSearchResults)
+		for i := range data.Results {
+			r := &data.Results[i]
+			r.Link = data.LinksPrefix + "/" + r.Path + data.LinksSuffix + "#R" + strconv.Itoa(r.Line)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = templates.ExecuteTemplate(w, "search", data)
+	if err != nil {
+		log.Println("search", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// search returns up to limit lines of the files in the tree, as they are after
+// the patch, that match re. scope restricts the search to changed files or
+// to added lines.
+func (nav *Navigator) search(re *regexp.Regexp, scope string, limit int) (results []searchResult, truncated bool) {
+	changedOnly := scope == searchChangedFiles || scope == searchChangedLines
+
+	var walk func(prefix string, folder *TreeFolder) bool
+	walk = func(prefix string, folder *TreeFolder) bool {
+		for _, entry := range folder.Entries {
+			path := prefix + entry.Name()
+			switch e := entry.(type) {
+			case *TreeFolder:
+				if changedOnly && nav.Changes[path] == nil {
+					continue
+				}
+				if !walk(path+"/", e) {
+					return false
+				}
+			case *TreeFile:
+				for _, line := range nav.searchableLines(path, e, scope) {
+					if !re.MatchString(line.text) {
+						continue
+					}
+					if len(results) == limit {
+						truncated = true
+						return false
+					}
+					results = append(results, searchResult{
+						Path:    path,
+						Line:    line.num,
+						Snippet: highlightMatches(line.text, re),
+					})
+				}
+			}
+		}
+		return true
+	}
+	if root, ok := nav.BaseDir.(*TreeFolder); ok {
+		walk("", root)
+	}
+
+	return results, truncated
+}
+
+type numberedLine struct {
+	num  int
+	text string
+}
+
+// searchableLines returns the lines of a file, as it is after the patch, that
+// are searched in the given scope. Binary and removed files have none.
+func (nav *Navigator) searchableLines(path string, f *TreeFile, scope string) []numberedLine {
+	stats := nav.Changes[path]
+	changedOnly := scope == searchChangedFiles || scope == searchChangedLines
+	if (changedOnly && stats == nil) || (stats != nil && stats.Removed) || f.IsBinary() {
+		return nil
+	}
+
+	contents, err := f.Contents()
+	if err != nil {
+		log.Println(path, err)
+		return nil
+	}
+	if strings.IndexByte(contents, 0) != -1 {
+		return nil
+	}
+
+	var ret []numberedLine
+	if stats == nil {
+		for i, line := range splitLines(contents) {
+			ret = append(ret, numberedLine{i + 1, line})
+		}
+		return ret
+	}
+
+	num := 0
+	for _, rec := range parseDiff(contents) {
+		if rec.Delta == difflib.LeftOnly {
+			continue
+		}
+		num++
+		if scope == searchChangedLines && rec.Delta != difflib.RightOnly {
+			continue
+		}
+		ret = append(ret, numberedLine{num, rec.Payload})
+	}
+	return ret
+}
+
+// highlightMatches escapes line and wraps what matches re in mark elements.
+func highlightMatches(line string, re *regexp.Regexp) template.HTML {
+	var buf bytes.Buffer
+	last := 0
+	for _, m := range re.FindAllStringIndex(line, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		buf.WriteString(html.EscapeString(line[last:m[0]]))
+		buf.WriteString("<mark>")
+		buf.WriteString(html.EscapeString(line[m[0]:m[1]]))
+		buf.WriteString("</mark>")
+		last = m[1]
+	}
+	buf.WriteString(html.EscapeString(line[last:]))
+	return template.HTML(buf.String())
+}
+
+type tplParam struct {
+	Name  string
+	Value string
+}
+
+// paramsExcept returns the query parameters of u but keys, sorted, so that
+// forms can carry them as hidden inputs.
+func paramsExcept(u *url.URL, keys ...string) []tplParam {
+	q := u.Query()
+	for _, key := range keys {
+		q.Del(key)
+	}
+
+	var names []string
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ret []tplParam
+	for _, name := range names {
+		for _, value := range q[name] {
+			ret = append(ret, tplParam{name, value})
+		}
+	}
+	return ret
+}
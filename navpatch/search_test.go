@@ -0,0 +1,72 @@
+package navpatch
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+
+	. "gopkg.in/check.v1"
+)
+
+const searchPatch = `diff --git a/foo/a.go b/foo/a.go
+index 1111111..2222222 100644
+--- a/foo/a.go
++++ b/foo/a.go
+@@ -3 +3 @@
+-func A() {}
++func A2() {}
+`
+
+func searchLocations(results []searchResult) []string {
+	var ret []string
+	for _, r := range results {
+		ret = append(ret, fmt.Sprintf("%s:%d", r.Path, r.Line))
+	}
+	return ret
+}
+
+func (s *PatchS) TestSearch(c *C) {
+	nav := testNavigator(c, testTree, searchPatch)
+
+	for _, t := range []struct {
+		expr, scope string
+		locations   []string
+	}{
+		{"func", "", []string{"foo/a.go:3", "foo/b.go:3"}},
+		{"func", searchChangedFiles, []string{"foo/a.go:3"}},
+		{"package", searchChangedFiles, []string{"foo/a.go:1"}},
+		{"package", searchChangedLines, nil},
+		{"A2", searchChangedLines, []string{"foo/a.go:3"}},
+		// Deleted lines aren't in the patched tree.
+		{`A\(`, "", nil},
+		{"hello", "", []string{"README:1"}},
+	} {
+		results, truncated := nav.search(regexp.MustCompile(t.expr), t.scope, 10)
+		c.Assert(truncated, Equals, false)
+		c.Assert(searchLocations(results), DeepEquals, t.locations, Commentf("%s in %q", t.expr, t.scope))
+	}
+
+	results, truncated := nav.search(regexp.MustCompile("o"), "", 2)
+	c.Assert(truncated, Equals, true)
+	c.Assert(searchLocations(results), DeepEquals, []string{"foo/a.go:1", "foo/b.go:1"})
+}
+
+func (s *PatchS) TestHighlightMatches(c *C) {
+	c.Assert(highlightMatches("a<b a", regexp.MustCompile("a")), Equals,
+		template.HTML("<mark>a</mark>&lt;b <mark>a</mark>"))
+	// Empty matches mark nothing.
+	c.Assert(highlightMatches("ab", regexp.MustCompile("x*")), Equals, template.HTML("ab"))
+}
+
+func (s *PatchS) TestServeSearch(c *C) {
+	nav := testNavigator(c, testTree, searchPatch)
+
+	w := serveTest(nav, "/foo?search=func&scope=changed")
+	body := w.Body.String()
+	c.Assert(body, Matches, `(?s).*<a href="/foo/a.go#R3">foo/a.go:3</a>.*`)
+	c.Assert(body, Not(Matches), `(?s).*foo/b.go:3.*`)
+	c.Assert(body, Matches, `(?s).*<a href="/foo">Navigator</a>.*`)
+
+	w = serveTest(nav, "/?search=(&regexp=1")
+	c.Assert(w.Body.String(), Matches, `(?s).*<p class="error">.*`)
+}
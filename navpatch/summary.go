@@ -0,0 +1,148 @@
+package navpatch
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Width in pixels of the widest bar in the summary's histogram.
+const summaryBarWidth = 100
+
+type tplSummaryData struct {
+	Title     string
+	Files     []tplSummaryFile
+	Additions int
+	Deletions int
+	Sort      string
+	// Whether generated and vendored files count in the totals.
+	ShowGenerated bool
+	pageLinks
+	reqURL *url.URL
+}
+
+func (d *tplSummaryData) WithParam(key, value string) string {
+	return urlWithParam(d.reqURL, key, value)
+}
+
+type tplSummaryFile struct {
+	*DiffStats
+	Path string
+	// Widths of the additions and deletions parts of the bar.
+	AdditionsBar int
+	DeletionsBar int
+}
+
+type summaryFiles struct {
+	files   []tplSummaryFile
+	byChurn bool
+}
+
+func (s summaryFiles) Len() int      { return len(s.files) }
+func (s summaryFiles) Swap(i, j int) { s.files[i], s.files[j] = s.files[j], s.files[i] }
+func (s summaryFiles) Less(i, j int) bool {
+	a, b := s.files[i], s.files[j]
+	if s.byChurn && a.Additions+a.Deletions != b.Additions+b.Deletions {
+		return a.Additions+a.Deletions > b.Additions+b.Deletions
+	}
+	return a.Path < b.Path
+}
+
+// serveSummary serves a flat list of the files changed by the patch, with
+// their stats and the totals, sorted by path or, with sort=churn, by changed
+// lines. As in folders, generated and vendored files are left out of the
+// totals unless generated=1.
+func (nav *Navigator) serveSummary(w http.ResponseWriter, req *http.Request, path, linksPrefix, linksSuffix string) {
+	data := &tplSummaryData{
+		Title:         "navpatch - summary",
+		Sort:          req.URL.Query().Get("sort"),
+		ShowGenerated: viewOptionsFromRequest(req).ShowGenerated,
+		pageLinks:     makePageLinks(path, linksPrefix, linksSuffix, "summary", "sort"),
+		reqURL:        req.URL,
+	}
+
+	maxChurn := 0
+	for _, path := range nav.ChangedFiles() {
+		stats := nav.Changes[path]
+		data.Files = append(data.Files, tplSummaryFile{DiffStats: stats, Path: path})
+		if !data.ShowGenerated && (stats.Generated || stats.Vendored) {
+			continue
+		}
+		data.Additions += stats.Additions
+		data.Deletions += stats.Deletions
+		if churn := stats.Additions + stats.Deletions; churn > maxChurn {
+			maxChurn = churn
+		}
+	}
+	for i := range data.Files {
+		f := &data.Files[i]
+		if maxChurn > 0 {
+			f.AdditionsBar = f.Additions * summaryBarWidth / maxChurn
+			f.DeletionsBar = f.Deletions * summaryBarWidth / maxChurn
+		}
+	}
+
+	sort.Sort(summaryFiles{data.Files, data.Sort == "churn"})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := templates.ExecuteTemplate(w, "summary", data)
+	if err != nil {
+		log.Println("summary", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// pageLinks are the links of the pages served instead of the navigator, like
+// the summary.
+type pageLinks struct {
+	// Link back to the navigator at the path the page was asked from.
+	Back string
+	// Links to the navigator, without the page's parameters.
+	LinksPrefix string
+	LinksSuffix string
+}
+
+// makePageLinks makes the links of a page asked from path, dropping the
+// page's query parameters params from the navigator's links.
+func makePageLinks(path, linksPrefix, linksSuffix string, params ...string) pageLinks {
+	l := pageLinks{
+		LinksPrefix: withoutParams(linksPrefix, params...),
+		LinksSuffix: withoutParams(linksSuffix, params...),
+	}
+	l.Back = l.LinksPrefix + path + l.LinksSuffix
+	return l
+}
+
+// withoutParams removes the query parameters keys from a links prefix or
+// suffix. The query string may end in a parameter waiting for its value, like
+// "?old=a&path=", which is kept as is.
+func withoutParams(link string, keys ...string) string {
+	i := strings.Index(link, "?")
+	if i == -1 {
+		return link
+	}
+
+	var kept []string
+	for _, param := range strings.Split(link[i+1:], "&") {
+		name := param
+		if j := strings.Index(param, "="); j != -1 {
+			name = param[:j]
+		}
+		drop := false
+		for _, key := range keys {
+			if name == key {
+				drop = true
+			}
+		}
+		if !drop {
+			kept = append(kept, param)
+		}
+	}
+
+	if len(kept) == 0 {
+		return link[:i]
+	}
+	return link[:i+1] + strings.Join(kept, "&")
+}
@@ -0,0 +1,49 @@
+package navpatch
+
+import (
+	"regexp"
+
+	. "gopkg.in/check.v1"
+)
+
+// summaryPatch changes long.txt by 4 lines, README by 3 and foo/b.go by 2.
+const summaryPatch = terminalPatch + `diff --git a/README b/README
+index 1111111..2222222 100644
+--- a/README
++++ b/README
+@@ -1 +1,4 @@
+ hello
++one
++two
++three
+`
+
+var summaryRow = regexp.MustCompile(`(?s)<td class="summary-path">\s*<a href="(/[^"]*)">.*?<span class="summary-bar addition" style="width: (\d+)px;"></span><span class="summary-bar deletion" style="width: (\d+)px;"></span>`)
+
+// summaryRows returns the path and bar widths of each row of a summary page.
+func summaryRows(body string) [][]string {
+	var rows [][]string
+	for _, m := range summaryRow.FindAllStringSubmatch(body, -1) {
+		rows = append(rows, m[1:])
+	}
+	return rows
+}
+
+func (s *PatchS) TestSummary(c *C) {
+	nav := testNavigator(c, longFileTree, summaryPatch)
+
+	body := serveTest(nav, "/?summary=1").Body.String()
+	c.Assert(body, Matches, `(?s).*3 files changed,\s*<span class="additions">\+6</span>\s*<span class="deletions">-3</span>.*`)
+	c.Assert(summaryRows(body), DeepEquals, [][]string{
+		{"/README", "75", "0"},
+		{"/foo/b.go", "25", "25"},
+		{"/long.txt", "50", "50"},
+	})
+
+	body = serveTest(nav, "/?summary=1&sort=churn").Body.String()
+	c.Assert(summaryRows(body), DeepEquals, [][]string{
+		{"/long.txt", "50", "50"},
+		{"/README", "75", "0"},
+		{"/foo/b.go", "25", "25"},
+	})
+}
@@ -2,36 +2,90 @@ package navpatch
 
 import (
 	"fmt"
-	"html"
 	"html/template"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
 type tplFullData struct {
-	Title    string
-	TreeData tplTreeData
-	Nav      *Navigator
+	Title        string
+	TreeData     tplTreeData
+	Nav          *Navigator
+	ExpandStep   int
+	CurrentPath  string
+	ParentLink   string
+	ChangedFiles []string
+}
+
+type tplBundleData struct {
+	*tplFullData
+	// Rendered "tree" templates by path, without leading slash.
+	Pages map[string]string
 }
 
 type tplTreeData struct {
 	Levels      []tplTreeDataLevel
 	Nav         *Navigator
 	LinksPrefix string
+	LinksSuffix string
+	Opts        viewOptions
+	// Static is set for exported pages, which have no server behind to
+	// change view options.
+	Static bool
+	reqURL *url.URL
+}
+
+// WithParam returns the URL of the current request with the query parameter
+// key set to value, or removed if value is empty.
+func (d tplTreeData) WithParam(key, value string) string {
+	return urlWithParam(d.reqURL, key, value)
+}
+
+// ParamsExcept returns the query parameters of the current request but keys.
+func (d tplTreeData) ParamsExcept(keys ...string) []tplParam {
+	return paramsExcept(d.reqURL, keys...)
+}
+
+func urlWithParam(reqURL *url.URL, key, value string) string {
+	u := *reqURL
+	q := u.Query()
+	if value == "" {
+		q.Del(key)
+	} else {
+		q.Set(key, value)
+	}
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
 }
 
 type tplTreeDataLevel struct {
 	Path    string
+	IsDir   bool
 	Entries []tplTreeDataLevelEntry
 	Body    string
+	Binary  *BinaryContents
+	Image   *tplImage
+	Stats   *DiffStats
 	Error   error
+	// Declaration changes of Go files, and of the Go packages in folders.
+	Outline  []declChange
+	Packages []packageOutline
+	// generated or vendored, for files that are.
+	Kind string
 }
 
 type tplTreeDataLevelEntry struct {
 	DiffStats
-	Name   string
-	IsDir  bool
-	IsOpen bool
+	Name    string
+	IsDir   bool
+	IsOpen  bool
+	Changed bool
+	// generated or vendored, for entries that are.
+	Kind string
+	// Exported API changes of the Go package in the folder.
+	APIChanges  int
+	APIBreaking int
 }
 
 var templates = template.Must(template.New("").Funcs(template.FuncMap{
@@ -44,29 +98,22 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
 	"toString": func(bs []byte) string {
 		return string(bs)
 	},
-	"colorify": func(diff string) template.HTML {
-		ret := `<table class="diff"><tbody>`
-		lines := strings.Split(diff, "\n")
-		for i, line := range lines {
-			if len(line) < 2 {
-				continue
-			}
-			class := ""
-			sign, line := line[:2], line[2:]
-			if sign == "+ " {
-				class = "addition"
-			} else if sign == "- " {
-				class = "deletion"
-			}
-
-			ret += fmt.Sprintf(`<tr class="%s">`, class)
-			ret += fmt.Sprintf(`<td class="line-num">%d</td>`, i)
-			ret += fmt.Sprintf(`<td class="line-content %s">%s</td>`, class, html.EscapeString(line))
-			ret += "</tr>"
+	"octal": func(mode int) string {
+		return fmt.Sprintf("%06o", mode)
+	},
+	"humanSize": func(n int) string {
+		switch {
+		case n < 0:
+			return "?"
+		case n < 1<<10:
+			return fmt.Sprintf("%d B", n)
+		case n < 1<<20:
+			return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+		default:
+			return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
 		}
-		ret += `</tbody></table>`
-		return template.HTML(ret)
 	},
+	"colorify": colorify,
 }).Parse(`
 {{define "full"}}
 <!DOCTYPE html>
@@ -74,6 +121,229 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
 <head>
   <meta charset="utf-8">
   <title>{{.Title}}</title>
+  {{template "style"}}
+</head>
+
+<body style="margin: 0; padding: 0; height: 100%;" data-current-path="{{.CurrentPath}}" data-parent-link="{{.ParentLink}}">
+  {{template "tree" .TreeData}}
+
+  {{template "scripts" .}}
+</body>
+</html>
+{{end}}
+
+{{define "bundle"}}
+<!DOCTYPE html>
+<html style="margin: 0; padding: 0; height: 100%;">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "style"}}
+</head>
+
+<body style="margin: 0; padding: 0; height: 100%;" data-current-path="" data-parent-link="#/">
+  <div id="tree"></div>
+
+  <script type="text/javascript">
+  // Pages are shown by the URL fragment: #/some/path. Other fragments, like
+  // line anchors, are left alone.
+  (function() {
+  	var pages = {{.Pages}};
+
+  	function route() {
+  		var hash = decodeURIComponent(location.hash);
+  		if (hash != "" && hash.indexOf("#/") != 0) {
+  			return;
+  		}
+  		// Links to lines of other pages, like #/some/file.go#R42.
+  		var anchor = "";
+  		var i = hash.indexOf("#", 1);
+  		if (i != -1) {
+  			anchor = hash.slice(i + 1);
+  			hash = hash.slice(0, i);
+  		}
+  		var parts = hash.slice(2).split("/").filter(function(p) { return p != ""; });
+  		var path = parts.join("/");
+
+  		var tree = document.getElementById("tree");
+  		if (pages.hasOwnProperty(path)) {
+  			tree.innerHTML = pages[path];
+  		} else {
+  			// Unchanged files and folders aren't included; show their folder
+  			// and a placeholder.
+  			tree.innerHTML = (pages[parts.slice(0, -1).join("/")] || "") +
+  				'<div class="folder" style="left: ' + (parts.length * 200) + 'px;">' +
+  				'<p class="not-included">Not included in this bundle.</p></div>';
+  			var links = tree.querySelectorAll("a.file-link");
+  			for (var i = 0; i < links.length; i++) {
+  				if (links[i].getAttribute("href") == "#/" + path) {
+  					links[i].classList.add("active");
+  				}
+  			}
+  		}
+
+  		// Scripts inserted through innerHTML don't run.
+  		var scripts = tree.querySelectorAll("script");
+  		for (var i = 0; i < scripts.length; i++) {
+  			var script = document.createElement("script");
+  			script.text = scripts[i].text;
+  			scripts[i].parentNode.replaceChild(script, scripts[i]);
+  		}
+
+  		document.title = "navpatch - /" + path;
+  		document.body.setAttribute("data-current-path", path);
+  		document.body.setAttribute("data-parent-link", "#/" + parts.slice(0, -1).join("/"));
+  		window.scrollTo(document.body.offsetWidth - 200, 0);
+  		var target = anchor && document.getElementById(anchor);
+  		if (target) {
+  			target.scrollIntoView();
+  		}
+  	}
+
+  	window.addEventListener("hashchange", route);
+  	route();
+  })();
+  </script>
+
+  {{template "scripts" .}}
+</body>
+</html>
+{{end}}
+
+{{define "summary"}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "style"}}
+</head>
+
+<body>
+  <div class="summary">
+  	<p class="view-modes">
+  		<a href="{{.Back}}">Navigator</a>
+  		Sort by
+  		<a href="{{.WithParam "sort" ""}}" class="{{if ne .Sort "churn"}}active{{end}}">path</a>
+  		<a href="{{.WithParam "sort" "churn"}}" class="{{if eq .Sort "churn"}}active{{end}}">churn</a>
+  		{{if .ShowGenerated}}<a href="{{.WithParam "generated" ""}}" class="active" title="Leave generated and vendored files out of the totals">Generated</a>
+  		{{else}}<a href="{{.WithParam "generated" "1"}}" title="Count generated and vendored files in the totals">Generated</a>{{end}}
+  	</p>
+
+  	<p class="summary-totals">
+  		{{len .Files}} files changed,
+  		<span class="additions">+{{.Additions}}</span>
+  		<span class="deletions">-{{.Deletions}}</span>
+  	</p>
+
+  	<table>
+  	{{range .Files}}
+  		<tr>
+  			<td class="summary-status">{{if .Added}}added{{else if .Removed}}removed{{else if .Renamed}}renamed{{else if .Copied}}copied{{else if .ModeChanged}}mode{{end}}</td>
+  			<td class="summary-path">
+  				<a href="{{concat $.LinksPrefix "/" .Path $.LinksSuffix}}">{{.Path}}</a>
+  				{{if .OldPath}}<span class="summary-status">from {{.OldPath}}</span>{{end}}
+  				{{if .Generated}}<span class="badge" title="generated{{if not $.ShowGenerated}}; not counted in the totals{{end}}">gen</span>
+  				{{else if .Vendored}}<span class="badge" title="vendored{{if not $.ShowGenerated}}; not counted in the totals{{end}}">vendor</span>{{end}}
+  			</td>
+  			<td class="additions">{{with .Additions}}+{{.}}{{end}}</td>
+  			<td class="deletions">{{with .Deletions}}-{{.}}{{end}}</td>
+  			<td><span class="summary-bar addition" style="width: {{.AdditionsBar}}px;"></span><span class="summary-bar deletion" style="width: {{.DeletionsBar}}px;"></span></td>
+  		</tr>
+  	{{end}}
+  	</table>
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "apidiff"}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "style"}}
+</head>
+
+<body>
+  <div class="summary">
+  	<p class="view-modes"><a href="{{.Back}}">Navigator</a></p>
+
+  	{{range .Packages}}
+  		<h3 class="api-package">
+  			<a href="{{concat $.LinksPrefix "/" .Path $.LinksSuffix}}">pkg {{.Name}}</a>
+  			<span class="summary-status">{{.Path}}</span>
+  			{{with .Breaking}}<span class="badge breaking">{{.}} potentially breaking</span>{{end}}
+  		</h3>
+  		<table class="api-changes">
+  		{{range .Changes}}
+  			<tr class="{{if .Breaking}}breaking{{end}}">
+  				<td class="outline-change {{.Change}}">{{.Change}}</td>
+  				<td><code>{{.Name}}</code></td>
+  				<td>
+  					{{with .Old}}<code class="deletions">{{.}}</code>{{end}}
+  					{{if and .Old .New}}<br>{{end}}
+  					{{with .New}}<code class="additions">{{.}}</code>{{end}}
+  				</td>
+  			</tr>
+  		{{end}}
+  		</table>
+  	{{else}}
+  		<p class="summary-totals">The patch doesn't change the exported API of any Go package.</p>
+  	{{end}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "search"}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "style"}}
+</head>
+
+<body>
+  <div class="summary">
+  	<p class="view-modes"><a href="{{.Back}}">Navigator</a></p>
+
+  	<form method="get" class="search-form">
+  		{{range .ParamsExcept "search" "regexp" "scope"}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}
+  		<input type="search" name="search" value="{{.Query}}" autofocus>
+  		<label><input type="checkbox" name="regexp" value="1" {{if .Regexp}}checked{{end}}> Regular expression</label>
+  		<select name="scope">
+  			<option value="">All files</option>
+  			<option value="changed" {{if eq .Scope "changed"}}selected{{end}}>Changed files</option>
+  			<option value="lines" {{if eq .Scope "lines"}}selected{{end}}>Changed lines</option>
+  		</select>
+  		<button type="submit">Search</button>
+  	</form>
+
+  	{{with .Error}}
+  		<p class="error">{{.}}</p>
+  	{{else}}{{if .Query}}
+  		<p class="summary-totals">
+  			{{len .Results}} results{{if .Truncated}}; only the first {{.Max}} are shown{{end}}.
+  		</p>
+
+  		<table>
+  		{{range .Results}}
+  			<tr>
+  				<td class="summary-path"><a href="{{.Link}}">{{.Path}}:{{.Line}}</a></td>
+  				<td class="search-snippet">{{.Snippet}}</td>
+  			</tr>
+  		{{end}}
+  		</table>
+  	{{end}}{{end}}
+  </div>
+</body>
+</html>
+{{end}}
+
+{{define "style"}}
   <style>
   body {
   	font-family: "Helvetica", sans-serif;
@@ -103,10 +373,52 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
   	background-color: #0bf;
   }
 
+  a.file-link.cursor {
+  	outline: 2px solid #0bf;
+  	outline-offset: -2px;
+  }
+
   a.file-link .link-right {
   	float: right;
   }
 
+  a.file-link .link-note {
+  	display: block;
+  	font-size: x-small;
+  	color: #888;
+  	overflow: hidden;
+  	text-overflow: ellipsis;
+  	white-space: nowrap;
+  }
+
+  a.file-link.active .link-note {
+  	color: white;
+  }
+
+  .badge {
+  	font-size: x-small;
+  	padding: 0 3px;
+  	border: 1px solid #ccc;
+  	border-radius: 3px;
+  	color: #666;
+  	vertical-align: middle;
+  }
+
+  .badge.changed {
+  	border-color: #c90;
+  	color: #c90;
+  }
+
+  .badge.breaking {
+  	border-color: #c33;
+  	color: #c33;
+  }
+
+  .active .badge {
+  	border-color: white;
+  	color: white;
+  }
+
   .dir-arrow {
   	font-size: xx-small;
   	vertical-align: middle;
@@ -142,14 +454,119 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
 
   table.diff .line-num {
   	width: 20px;
+  	padding: 0 5px;
   	text-align: right;
   	color: #aaa;
   }
 
+  table.diff .line-num a {
+  	color: inherit;
+  	text-decoration: none;
+  }
+
+  table.diff .line-num a:hover {
+  	color: #333;
+  }
+
+  table.diff .line-num:target {
+  	color: #333;
+  	background-color: #ffa;
+  }
+
   table.diff .line-content {
     white-space: pre;
   }
 
+  table.diff.split {
+  	width: 1200px;
+  }
+
+  table.diff.split .line-content {
+  	width: 50%;
+  }
+
+  table.diff tr.current-hunk td.line-num {
+  	border-left: 3px solid #0bf;
+  }
+
+  table.diff tr.gap td {
+  	padding: 3px 10px;
+  	color: #666;
+  	background-color: #eef5ff;
+  	font-family: "Helvetica", sans-serif;
+  	font-size: small;
+  }
+
+  table.diff tr.gap a {
+  	color: #06c;
+  	text-decoration: none;
+  }
+
+  table.diff .addition .changed {
+  	background-color: rgb(160, 240, 160);
+  }
+
+  table.diff .deletion .changed {
+  	background-color: rgb(240, 170, 170);
+  }
+
+  table.diff .addition.moved {
+  	background-color: rgb(219, 232, 255);
+  }
+
+  table.diff .deletion.moved {
+  	background-color: rgb(236, 224, 255);
+  }
+
+  table.diff .moved .changed {
+  	background-color: transparent;
+  }
+
+  table.diff .moved-link {
+  	float: right;
+  	margin-left: 10px;
+  	font-family: "Helvetica", sans-serif;
+  	font-size: x-small;
+  	color: #63c;
+  }
+
+  .moved-count {
+  	font-size: small;
+  	color: #63c;
+  	font-weight: bold;
+  	vertical-align: middle;
+  }
+
+  .active .moved-count {
+  	color: white;
+  }
+
+  table.diff .empty {
+  	background-color: #f6f6f6;
+  }
+
+  .view-modes {
+  	padding: 5px 10px;
+  	font-size: small;
+  }
+
+  .view-modes a {
+  	padding: 2px 6px;
+  	color: #333;
+  	text-decoration: none;
+  }
+
+  .view-modes select {
+  	display: block;
+  	margin-top: 4px;
+  	font-size: x-small;
+  }
+
+  .view-modes a.active {
+  	color: white;
+  	background-color: #0bf;
+  }
+
   table.diff .addition {
   	background-color: rgb(219, 255, 219);
   }
@@ -167,6 +584,192 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
     border-right: 1px solid #aaa;
   }
 
+  .outline {
+  	margin: 0;
+  	padding: 5px 10px;
+  	width: 780px;
+  	list-style: none;
+  	font-size: small;
+  	border-bottom: 1px solid #eee;
+  	columns: 3;
+  }
+
+  .outline a {
+  	color: #333;
+  	text-decoration: none;
+  }
+
+  .outline a:hover code {
+  	text-decoration: underline;
+  }
+
+  .outline-change {
+  	font-size: x-small;
+  	color: #888;
+  }
+
+  .outline-change.added {
+  	color: #3a3;
+  }
+
+  .outline-change.removed {
+  	color: #c33;
+  }
+
+  .outline-change.modified {
+  	color: #c90;
+  }
+
+  .package-outline {
+  	padding: 5px 10px;
+  	width: 179px;
+  	font-size: x-small;
+  	background-color: #f5f8ff;
+  	border-bottom: 1px solid #dde;
+  }
+
+  .package-outline summary {
+  	cursor: pointer;
+  }
+
+  .package-outline .outline {
+  	width: auto;
+  	padding: 3px 0 0 0;
+  	border-bottom: none;
+  	font-size: x-small;
+  	columns: 1;
+  }
+
+  a.file-link.generated, a.file-link.vendored {
+  	color: #999;
+  }
+
+  .generated-diff {
+  	margin: 0;
+  	padding: 10px;
+  	width: 780px;
+  	font-size: small;
+  	color: #666;
+  	background-color: #f6f6f6;
+  }
+
+  .generated-diff a, .generated-diff summary {
+  	color: #06c;
+  	cursor: pointer;
+  }
+
+  .banner {
+  	padding: 5px 10px;
+  	width: 780px;
+  	font-size: small;
+  	background-color: #ffd;
+  	border-bottom: 1px solid #eeb;
+  	color: #660;
+  }
+
+  .binary {
+  	padding: 10px;
+  	width: 780px;
+  	color: #666;
+  	font-style: italic;
+  }
+
+  .image-diff {
+  	padding: 10px;
+  	width: 780px;
+  }
+
+  .image-modes {
+  	margin-bottom: 10px;
+  	font-size: small;
+  }
+
+  .image-modes a {
+  	padding: 3px 8px;
+  	border: 1px solid #ccc;
+  	color: #333;
+  	text-decoration: none;
+  }
+
+  .image-modes a.active {
+  	color: white;
+  	background-color: #0bf;
+  	border-color: #0bf;
+  }
+
+  .image-frame {
+  	display: inline-block;
+  	vertical-align: top;
+  	max-width: 370px;
+  	margin: 0 5px 5px 0;
+  	padding: 5px;
+  	border: 1px solid #ddd;
+  }
+
+  .image-frame.deletion {
+  	border-color: #c66;
+  }
+
+  .image-frame.addition {
+  	border-color: #6c6;
+  }
+
+  .image-frame img {
+  	display: block;
+  	max-width: 100%;
+  }
+
+  .image-label {
+  	margin: 5px 0 0 0;
+  	font-size: x-small;
+  	color: #888;
+  }
+
+  .image-swipe, .image-onion {
+  	display: none;
+  }
+
+  .image-diff.swipe .image-side, .image-diff.onion .image-side {
+  	display: none;
+  }
+
+  .image-diff.swipe .image-swipe, .image-diff.onion .image-onion {
+  	display: block;
+  }
+
+  .image-stack {
+  	position: relative;
+  	display: inline-block;
+  	border: 1px solid #ddd;
+  }
+
+  .image-stack img {
+  	display: block;
+  	max-width: 780px;
+  }
+
+  .image-stack .image-over {
+  	position: absolute;
+  	top: 0;
+  	left: 0;
+  }
+
+  .image-swipe .image-over {
+  	height: 100%;
+  	overflow: hidden;
+  	border-right: 1px solid #c66;
+  }
+
+  .image-swipe .image-over img {
+  	max-width: none;
+  }
+
+  .image-diff input[type=range] {
+  	display: block;
+  	width: 300px;
+  	margin-top: 10px;
+  }
+
   .error {
     padding: 10px;
     background-color: #faa;
@@ -176,22 +779,465 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
     min-weight: 100%;
     width: 800px;
   }
-  </style>
-</head>
 
-<body style="margin: 0; padding: 0; height: 100%;">
-  {{template "tree" .TreeData}}
+  .summary {
+  	padding: 10px;
+  	font-size: small;
+  }
+
+  .api-package {
+  	margin: 15px 0 5px 0;
+  	font-size: small;
+  }
+
+  .api-package a {
+  	color: #333;
+  }
+
+  .api-changes tr.breaking td:first-child {
+  	border-left: 3px solid #c33;
+  	padding-left: 5px;
+  }
+
+  .outline-change.changed {
+  	color: #c90;
+  }
+
+  .summary td {
+  	padding: 2px 10px 2px 0;
+  }
+
+  .summary-status {
+  	color: #888;
+  }
+
+  .summary-bar {
+  	display: inline-block;
+  	height: 8px;
+  }
+
+  .summary-bar.addition {
+  	background-color: #3c3;
+  }
+
+  .summary-bar.deletion {
+  	background-color: #c33;
+  }
+
+  .search-form input[type=search] {
+  	width: 100%;
+  	box-sizing: border-box;
+  	margin-top: 4px;
+  	font-size: x-small;
+  }
+
+  .summary .search-form input[type=search] {
+  	width: 300px;
+  	font-size: small;
+  }
+
+  .search-snippet {
+  	font-family: monospace;
+  	white-space: pre;
+  }
+
+  .finder {
+  	display: none;
+  	position: fixed;
+  	top: 50px;
+  	left: 50%;
+  	width: 500px;
+  	margin-left: -250px;
+  	padding: 5px;
+  	background-color: white;
+  	border: 1px solid #aaa;
+  	box-shadow: 0 2px 10px rgba(0, 0, 0, 0.3);
+  	z-index: 10;
+  }
+
+  .finder input {
+  	width: 100%;
+  	box-sizing: border-box;
+  }
+
+  .finder ul {
+  	margin: 5px 0 0 0;
+  	padding: 0;
+  	list-style: none;
+  	font-size: small;
+  }
+
+  .finder li {
+  	padding: 3px 5px;
+  	cursor: pointer;
+  	color: #666;
+  }
+
+  .finder li.changed {
+  	color: #333;
+  	font-weight: bold;
+  }
+
+  .finder li.selected {
+  	color: white;
+  	background-color: #0bf;
+  }
+
+  .tok-comment {
+  	color: #6a737d;
+  	font-style: italic;
+  }
+
+  .tok-string {
+  	color: #032f62;
+  }
+
+  .tok-keyword {
+  	color: #d73a49;
+  }
+
+  .tok-number {
+  	color: #005cc5;
+  }
+
+  .not-included {
+  	padding: 10px;
+  	color: #888;
+  	font-size: small;
+  }
+  </style>
+{{end}}
 
+{{define "scripts"}}
   <script type="text/javascript">
   window.scrollTo(document.body.offsetWidth - 200, 0);
+
+  // Collapsed unchanged lines are fetched from the server as table rows.
+  (function() {
+  	function expand(gap, from, to, done) {
+  		var url = new URL(location.href);
+  		url.hash = "";
+  		url.searchParams.set("lines", from + "-" + to);
+  		var xhr = new XMLHttpRequest();
+  		xhr.open("GET", url.toString());
+  		xhr.onload = function() {
+  			if (xhr.status != 200) {
+  				return;
+  			}
+  			var tbody = document.createElement("tbody");
+  			tbody.innerHTML = xhr.responseText;
+  			var gapFrom = +gap.getAttribute("data-from");
+  			var gapTo = +gap.getAttribute("data-to");
+  			var before = from == gapFrom;
+  			var ref = before ? gap : gap.nextSibling;
+  			while (tbody.firstChild) {
+  				gap.parentNode.insertBefore(tbody.firstChild, ref);
+  			}
+  			if (before) {
+  				var n = to - from;
+  				gap.setAttribute("data-old", +gap.getAttribute("data-old") + n);
+  				gap.setAttribute("data-new", +gap.getAttribute("data-new") + n);
+  				gapFrom = to;
+  			} else {
+  				gapTo = from;
+  			}
+  			gap.setAttribute("data-from", gapFrom);
+  			gap.setAttribute("data-to", gapTo);
+  			gap.querySelector(".gap-count").textContent = gapTo - gapFrom;
+  			if (gapFrom >= gapTo) {
+  				gap.parentNode.removeChild(gap);
+  			}
+  			if (done) {
+  				done();
+  			}
+  		};
+  		xhr.send();
+  	}
+
+  	document.addEventListener("click", function(e) {
+  		var link = e.target.closest("tr.gap a");
+  		if (!link) {
+  			return;
+  		}
+  		e.preventDefault();
+  		var gap = link.closest("tr.gap");
+  		var from = +gap.getAttribute("data-from");
+  		var to = +gap.getAttribute("data-to");
+  		if (link.className == "expand-down") {
+  			expand(gap, from, Math.min(from + {{.ExpandStep}}, to));
+  		} else if (link.className == "expand-up") {
+  			expand(gap, Math.max(to - {{.ExpandStep}}, from), to);
+  		} else {
+  			expand(gap, from, to);
+  		}
+  	});
+
+  	// Links to collapsed lines, like #L42, expand them first.
+  	var m = /^#([LR])(\d+)$/.exec(location.hash);
+  	if (m && !document.getElementById(m[1] + m[2])) {
+  		var gaps = document.querySelectorAll("tr.gap");
+  		for (var i = 0; i < gaps.length; i++) {
+  			var first = +gaps[i].getAttribute(m[1] == "L" ? "data-old" : "data-new");
+  			var n = gaps[i].getAttribute("data-to") - gaps[i].getAttribute("data-from");
+  			if (m[2] >= first && m[2] < first + n) {
+  				expand(gaps[i], +gaps[i].getAttribute("data-from"), +gaps[i].getAttribute("data-to"), function() {
+  					location.hash = "";
+  					location.hash = m[0];
+  				});
+  			}
+  		}
+  	}
+  })();
+
+  // Keyboard navigation:
+  //   n / p          next / previous change in the open file
+  //   j / k, ↓ / ↑   move through the entries of the last folder
+  //   Enter, →       open the selected entry
+  //   Backspace, ←   close the open file or folder
+  //   ] / [          next / previous changed file in the whole tree
+  //   t              go to a file, below
+  (function() {
+  	// The current path and its parent are read from the body, as they change
+  	// without reloading the page in bundles.
+  	var changedFiles = {{.ChangedFiles}};
+  	var linksPrefix = {{.TreeData.LinksPrefix}};
+  	var linksSuffix = {{.TreeData.LinksSuffix}};
+
+  	function go(path) {
+  		location.href = linksPrefix + "/" + path + linksSuffix;
+  	}
+
+  	function hunks() {
+  		var ret = [];
+  		var rows = document.querySelectorAll("table.diff tr");
+  		var inHunk = false;
+  		for (var i = 0; i < rows.length; i++) {
+  			var changed = !!rows[i].querySelector(".addition, .deletion");
+  			if (changed && !inHunk) {
+  				ret.push(rows[i]);
+  			}
+  			inHunk = changed;
+  		}
+  		return ret;
+  	}
+
+  	var currentHunk = -1;
+  	function moveHunk(step) {
+  		var hs = hunks();
+  		if (hs.length == 0) {
+  			return;
+  		}
+  		if (currentHunk >= 0 && currentHunk < hs.length) {
+  			hs[currentHunk].classList.remove("current-hunk");
+  		}
+  		currentHunk = Math.min(Math.max(currentHunk + step, 0), hs.length - 1);
+  		hs[currentHunk].classList.add("current-hunk");
+  		hs[currentHunk].scrollIntoView({block: "center"});
+  	}
+
+  	function moveFile(step) {
+  		if (changedFiles.length == 0) {
+  			return;
+  		}
+  		var currentPath = document.body.getAttribute("data-current-path");
+  		var i = changedFiles.indexOf(currentPath);
+  		if (i == -1) {
+  			// In a folder: go to its first or last changed file.
+  			var inside = changedFiles.filter(function(f) {
+  				return currentPath == "" || f.indexOf(currentPath + "/") == 0;
+  			});
+  			if (inside.length == 0) {
+  				inside = changedFiles;
+  			}
+  			go(step > 0 ? inside[0] : inside[inside.length - 1]);
+  			return;
+  		}
+  		go(changedFiles[(i + step + changedFiles.length) % changedFiles.length]);
+  	}
+
+  	function lastColumnLinks() {
+  		var columns = document.querySelectorAll("div.folder");
+  		for (var i = columns.length - 1; i >= 0; i--) {
+  			var links = columns[i].querySelectorAll("a.file-link");
+  			if (links.length > 0) {
+  				return links;
+  			}
+  		}
+  		return [];
+  	}
+
+  	function moveCursor(step) {
+  		var links = lastColumnLinks();
+  		if (links.length == 0) {
+  			return;
+  		}
+  		var i = -1;
+  		for (var j = 0; j < links.length; j++) {
+  			if (links[j].classList.contains("cursor")) {
+  				i = j;
+  			}
+  		}
+  		if (i == -1) {
+  			for (var j = 0; j < links.length; j++) {
+  				if (links[j].classList.contains("active")) {
+  					i = j;
+  				}
+  			}
+  		}
+  		if (i >= 0) {
+  			links[i].classList.remove("cursor");
+  		}
+  		i = i == -1 ? (step > 0 ? 0 : links.length - 1) : Math.min(Math.max(i + step, 0), links.length - 1);
+  		links[i].classList.add("cursor");
+  		links[i].scrollIntoView({block: "nearest"});
+  	}
+
+  	function openCursor() {
+  		var cursor = document.querySelector("a.file-link.cursor");
+  		if (cursor) {
+  			location.href = cursor.href;
+  		}
+  	}
+
+  	document.addEventListener("keydown", function(e) {
+  		if (e.ctrlKey || e.metaKey || e.altKey) {
+  			return;
+  		}
+  		var tag = e.target.tagName;
+  		if (tag == "INPUT" || tag == "TEXTAREA" || tag == "SELECT") {
+  			return;
+  		}
+  		switch (e.key) {
+  		case "n": moveHunk(1); break;
+  		case "p": moveHunk(-1); break;
+  		case "j": case "ArrowDown": moveCursor(1); break;
+  		case "k": case "ArrowUp": moveCursor(-1); break;
+  		case "Enter": case "ArrowRight": openCursor(); break;
+  		case "Backspace": case "ArrowLeft": location.href = document.body.getAttribute("data-parent-link"); break;
+  		case "]": moveFile(1); break;
+  		case "[": moveFile(-1); break;
+  		default: return;
+  		}
+  		e.preventDefault();
+  	});
+  })();
+
+  {{if not .TreeData.Static}}
+  // File finder: t opens it, and the server fuzzy-matches what's typed
+  // against every path in the tree.
+  (function() {
+  	var linksPrefix = {{.TreeData.LinksPrefix}};
+  	var linksSuffix = {{.TreeData.LinksSuffix}};
+  	var finder, input, list;
+  	var results = [];
+  	var selected = 0;
+
+  	function open() {
+  		if (!finder) {
+  			finder = document.createElement("div");
+  			finder.className = "finder";
+  			finder.innerHTML = '<input type="text" placeholder="Go to file"><ul></ul>';
+  			document.body.appendChild(finder);
+  			input = finder.querySelector("input");
+  			list = finder.querySelector("ul");
+  			input.addEventListener("input", find);
+  			input.addEventListener("blur", close);
+  			input.addEventListener("keydown", function(e) {
+  				switch (e.key) {
+  				case "ArrowDown": select(selected + 1); break;
+  				case "ArrowUp": select(selected - 1); break;
+  				case "Enter": if (results[selected]) { go(results[selected].path); } break;
+  				case "Escape": close(); break;
+  				default: return;
+  				}
+  				e.preventDefault();
+  			});
+  		}
+  		finder.style.display = "block";
+  		input.value = "";
+  		input.focus();
+  		find();
+  	}
+
+  	function close() {
+  		finder.style.display = "none";
+  	}
+
+  	function go(path) {
+  		location.href = linksPrefix + "/" + path + linksSuffix;
+  	}
+
+  	function find() {
+  		var query = input.value;
+  		var url = new URL(location.href);
+  		url.hash = "";
+  		url.searchParams.set("find", query);
+  		var xhr = new XMLHttpRequest();
+  		xhr.open("GET", url.toString());
+  		xhr.onload = function() {
+  			// Answers to older queries are dropped.
+  			if (xhr.status != 200 || input.value != query) {
+  				return;
+  			}
+  			results = JSON.parse(xhr.responseText);
+  			select(0);
+  		};
+  		xhr.send();
+  	}
+
+  	function select(i) {
+  		selected = Math.min(Math.max(i, 0), results.length - 1);
+  		list.innerHTML = "";
+  		results.forEach(function(r, j) {
+  			var li = document.createElement("li");
+  			li.textContent = r.path;
+  			li.className = (r.changed ? "changed " : "") + (j == selected ? "selected" : "");
+  			li.onmousedown = function() { go(r.path); };
+  			list.appendChild(li);
+  		});
+  	}
+
+  	document.addEventListener("keydown", function(e) {
+  		var tag = e.target.tagName;
+  		if (e.key != "t" || e.ctrlKey || e.metaKey || e.altKey ||
+  			tag == "INPUT" || tag == "TEXTAREA" || tag == "SELECT") {
+  			return;
+  		}
+  		e.preventDefault();
+  		open();
+  	});
+  })();
+  {{end}}
   </script>
-</body>
-</html>
 {{end}}
 
 {{define "tree"}}
 {{range $i, $level := .Levels}}
 	<div class="folder" style="left: {{marginLeft $i}}px;">
+	{{if and (eq $i 0) (not $.Static)}}
+		<div class="view-modes">
+			{{if $.Opts.ChangedOnly}}<a href="{{$.WithParam "changed" ""}}" class="active" title="Show all files">Changed only</a>
+			{{else}}<a href="{{$.WithParam "changed" "1"}}" title="Hide files the patch didn't change">Changed only</a>{{end}}
+			{{if $.Opts.Compact}}<a href="{{$.WithParam "compact" ""}}" class="active" title="Show every folder">Compact</a>
+			{{else}}<a href="{{$.WithParam "compact" "1"}}" title="Show chains of single folders as one row">Compact</a>{{end}}
+			{{template "whitespace-toggle" $}}
+			{{if $.Opts.ShowGenerated}}<a href="{{$.WithParam "generated" ""}}" class="active" title="Leave generated and vendored files out of folder totals">Generated</a>
+			{{else}}<a href="{{$.WithParam "generated" "1"}}" title="Count generated and vendored files in folder totals and show their diffs">Generated</a>{{end}}
+			<a href="{{$.WithParam "summary" "1"}}" title="List all changed files">Summary</a>
+			<a href="{{$.WithParam "apidiff" "1"}}" title="List the changes to the exported API of Go packages">API</a>
+			<form method="get" class="search-form">
+				{{range $.ParamsExcept "search" "regexp" "scope"}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">{{end}}
+				<input type="search" name="search" placeholder="Search">
+			</form>
+			<select class="entry-order" onchange="location.href = this.value;" title="Order of the folder entries">
+				<option value="{{$.WithParam "order" "default"}}">Default order</option>
+				<option value="{{$.WithParam "order" "churn"}}" {{if eq $.Opts.Order "churn"}}selected{{end}}>By churn</option>
+				<option value="{{$.WithParam "order" "additions"}}" {{if eq $.Opts.Order "additions"}}selected{{end}}>By additions</option>
+				<option value="{{$.WithParam "order" "changed"}}" {{if eq $.Opts.Order "changed"}}selected{{end}}>Changed first</option>
+				<option value="{{$.WithParam "order" "folders"}}" {{if eq $.Opts.Order "folders"}}selected{{end}}>Folders first</option>
+			</select>
+		</div>
+	{{end}}
 
   {{with .Error}}
     <div class="error">
@@ -210,22 +1256,201 @@ var templates = template.Must(template.New("").Funcs(template.FuncMap{
 
       <pre>{{toString $.Nav.RawPatch}}</pre>
     </div>
+	{{else}}{{if .Image}}
+		{{template "banners" .Stats}}
+		{{template "image" .Image}}
+	{{else}}{{if .Binary}}
+		{{template "banners" .Stats}}
+		{{template "binary" .}}
 	{{else}}{{with .Body}}
-		{{colorify .}}
+		{{template "banners" $level.Stats}}
+		{{with $level.Stats}}{{if not (or .Additions .Deletions)}}<div class="banner">No line changes</div>{{end}}{{end}}
+		{{if and $level.Stats (not $.Static)}}
+			<div class="view-modes">
+				<a href="{{$.WithParam "view" ""}}" class="{{if not $.Opts.Split}}active{{end}}">Unified</a>
+				<a href="{{$.WithParam "view" "split"}}" class="{{if $.Opts.Split}}active{{end}}">Split</a>
+				{{template "whitespace-toggle" $}}
+			</div>
+		{{end}}
+		{{with $level.Outline}}
+			<ul class="outline">
+			{{range .}}
+				<li><a href="#{{.Anchor}}"><span class="outline-change {{.Change}}">{{.Change}}</span> {{.Kind}} <code>{{.Name}}</code></a></li>
+			{{end}}
+			</ul>
+		{{end}}
+		{{if and $level.Stats $level.Kind (not $.Opts.ShowGenerated)}}
+			{{if $.Static}}
+				<details class="generated-diff">
+					<summary>Show {{$level.Kind}} diff</summary>
+					{{colorify . $level.Path ($.Moves $level.Path $level.Stats) $.Opts.Split $.Opts.Context}}
+				</details>
+			{{else}}
+				<p class="generated-diff">
+					This file is {{$level.Kind}}.
+					<a href="{{$.WithParam "generated" "1"}}">Show {{$level.Kind}} diff</a>
+				</p>
+			{{end}}
+		{{else if $level.Stats}}
+			{{colorify . $level.Path ($.Moves $level.Path $level.Stats) $.Opts.Split $.Opts.Context}}
+		{{else}}
+			{{colorify . $level.Path nil false -1}}
+		{{end}}
 	{{else}}
-		{{range .Entries}}
-			<a class="file-link {{with .IsOpen}}active{{end}}" href="{{concat $.LinksPrefix $level.Path "/" .Name }}">
+		{{range .Packages}}
+			<details class="package-outline">
+				<summary>pkg <code>{{.Name}}</code>: {{.Summary}}</summary>
+				<ul class="outline">
+				{{range .Changes}}
+					<li><a href="{{concat $.LinksPrefix $level.Path "/" .File $.LinksSuffix}}#{{.Anchor}}" title="{{.File}}"><span class="outline-change {{.Change}}">{{.Change}}</span> {{.Kind}} <code>{{.Name}}</code></a></li>
+				{{end}}
+				</ul>
+			</details>
+		{{end}}
+		{{range $entry := .Entries}}
+			<a class="file-link {{with .IsOpen}}active{{end}} {{.Kind}}" href="{{concat $.LinksPrefix $level.Path "/" .Name $.LinksSuffix}}">
 				<span class="link-name">{{.Name}}</span>
+				{{if .IsSymlink}}<span class="badge {{if .ModeChanged}}changed{{end}}" title="symbolic link ({{octal .Mode}})">link</span>
+				{{else if .IsExecutable}}<span class="badge {{if .ModeChanged}}changed{{end}}" title="executable ({{octal .Mode}})">exec</span>
+				{{else if .ModeChanged}}<span class="badge changed" title="mode {{octal .OldMode}} → {{octal .NewMode}}">mode</span>{{end}}
+				{{if eq .Kind "generated"}}<span class="badge" title="generated; not counted in folder totals">gen</span>
+				{{else if eq .Kind "vendored"}}<span class="badge" title="vendored; not counted in folder totals">vendor</span>{{end}}
+				{{with .APIChanges}}<span class="badge {{if $entry.APIBreaking}}breaking{{else}}changed{{end}}" title="{{.}} exported API {{if eq . 1}}change{{else}}changes{{end}}{{with $entry.APIBreaking}}, {{.}} potentially breaking{{end}}">API</span>{{end}}
 				<span class="link-right">
 				{{with .Additions}}<span class="additions">+{{.}}</span>{{end}}
 				{{with .Deletions}}<span class="deletions">-{{.}}</span>{{end}}
+				{{if or .MovedIn .MovedOut}}<span class="moved-count" title="{{.MovedIn}} lines moved in, {{.MovedOut}} moved out">~{{.Moved}}</span>{{end}}
 				{{with .IsDir}}<span class="dir-arrow">▶</span>{{end}}
 				</span>
+				{{if .OldPath}}<span class="link-note" title="{{.OldPath}}">{{if .Copied}}copied{{else}}renamed{{end}} from {{.OldPath}}</span>{{end}}
+				{{if .Binary}}<span class="link-note">binary, {{if .Added}}{{humanSize .NewSize}}{{else if .Removed}}{{humanSize .OldSize}}{{else}}{{humanSize .OldSize}} → {{humanSize .NewSize}}{{end}}</span>{{end}}
 			</a>
 		{{end}}
-	{{end}}{{end}}
+	{{end}}{{end}}{{end}}{{end}}
+
+	</div>
+{{end}}
+{{end}}
+
+{{define "whitespace-toggle"}}
+	{{if .Nav.Whitespace}}<a href="{{.WithParam "w" "0"}}" class="active" title="Show changes in whitespace">Ignore whitespace</a>
+	{{else}}<a href="{{.WithParam "w" "1"}}" title="Hide changes in whitespace only">Ignore whitespace</a>{{end}}
+{{end}}
+
+{{define "banners"}}
+{{with .}}
+	{{if .OldPath}}<div class="banner">{{if .Copied}}Copied{{else}}Renamed{{end}} from {{.OldPath}}</div>{{end}}
+	{{if .ModeChanged}}<div class="banner">Mode changed from {{octal .OldMode}} to {{octal .NewMode}}</div>{{end}}
+{{end}}
+{{end}}
+
+{{define "image"}}
+<div class="image-diff">
+	{{if and .Changed .Old .New}}
+	<div class="image-modes">
+		<a href="#" class="active" data-mode="">2-up</a>
+		<a href="#" data-mode="swipe">Swipe</a>
+		<a href="#" data-mode="onion">Onion skin</a>
+	</div>
+	{{end}}
 
+	<div class="image-side">
+	{{if .Changed}}
+		{{with .Old}}<div class="image-frame deletion"><img class="image-old" src="{{.}}"><p class="image-label">Old</p></div>{{end}}
+		{{with .New}}<div class="image-frame addition"><img class="image-new" src="{{.}}"><p class="image-label">New</p></div>{{end}}
+	{{else}}
+		<div class="image-frame"><img src="{{.New}}"></div>
+	{{end}}
 	</div>
+
+	{{if and .Changed .Old .New}}
+	<div class="image-swipe">
+		<div class="image-stack">
+			<img class="image-under" data-src="new">
+			<div class="image-over" style="width: 50%;"><img data-src="old"></div>
+		</div>
+		<input type="range" min="0" max="100" value="50">
+	</div>
+
+	<div class="image-onion">
+		<div class="image-stack">
+			<img class="image-under" data-src="old">
+			<img class="image-over" data-src="new" style="opacity: 0.5;">
+		</div>
+		<input type="range" min="0" max="100" value="50">
+	</div>
+	{{end}}
+</div>
+
+<script type="text/javascript">
+(function() {
+	var diffs = document.querySelectorAll(".image-diff");
+	for (var i = 0; i < diffs.length; i++) {
+		setUpImageDiff(diffs[i]);
+	}
+
+	function setUpImageDiff(diff) {
+		var old = diff.querySelector(".image-old");
+		var nu = diff.querySelector(".image-new");
+		if (!old || !nu) {
+			return;
+		}
+
+		// Swipe and onion skin reuse the 2-up images' data so that it's not
+		// sent thrice.
+		var imgs = diff.querySelectorAll("img[data-src]");
+		for (var i = 0; i < imgs.length; i++) {
+			imgs[i].src = imgs[i].getAttribute("data-src") == "old" ? old.src : nu.src;
+		}
+
+		var swipeOver = diff.querySelector(".image-swipe .image-over");
+		var swipeUnder = diff.querySelector(".image-swipe .image-under");
+		diff.querySelector(".image-swipe input").oninput = function() {
+			swipeOver.style.width = this.value + "%";
+		};
+
+		var onionOver = diff.querySelector(".image-onion .image-over");
+		diff.querySelector(".image-onion input").oninput = function() {
+			onionOver.style.opacity = this.value / 100;
+		};
+
+		var links = diff.querySelectorAll(".image-modes a");
+		for (var i = 0; i < links.length; i++) {
+			links[i].onclick = function(e) {
+				e.preventDefault();
+				for (var j = 0; j < links.length; j++) {
+					links[j].className = "";
+				}
+				this.className = "active";
+				diff.className = "image-diff " + this.getAttribute("data-mode");
+				// The swiped image must keep the size of the one below.
+				swipeOver.querySelector("img").style.width = swipeUnder.offsetWidth + "px";
+			};
+		}
+	}
+})();
+</script>
+{{end}}
+
+{{define "binary-size"}}{{if ge . 0}}{{.}} bytes{{else}}unknown size{{end}}{{end}}
+
+{{define "binary"}}
+<div class="binary">
+{{with .Stats}}
+	{{if .Added}}
+		Binary file added ({{template "binary-size" .NewSize}}).
+	{{else if .Removed}}
+		Binary file removed ({{template "binary-size" .OldSize}}).
+	{{else if not .Binary}}
+		Binary file ({{len $.Binary.New}} bytes).
+	{{else if ge .NewSize 0}}
+		Binary file changed from {{len $.Binary.Old}} to {{len $.Binary.New}} bytes.
+	{{else}}
+		Binary file changed. Its new contents aren't included in the patch; use <code>git diff --binary</code> to get them.
+	{{end}}
+{{else}}
+	Binary file ({{len .Binary.New}} bytes).
 {{end}}
+</div>
 {{end}}
 `))
@@ -0,0 +1,338 @@
+package navpatch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aryann/difflib"
+	"github.com/nsf/termbox-go"
+)
+
+// Width of each folder column in the terminal, minimum width of the pane
+// showing the open file, and unchanged lines kept above a change when jumping
+// to it.
+const (
+	termColumnWidth  = 32
+	termMinBodyWidth = 40
+	termContext      = 3
+)
+
+// terminalView is the state of the terminal navigator: the path that is
+// open, as in the web interface, plus a cursor in the last open folder, the
+// scroll position of the open file and whether generated files are shown.
+type terminalView struct {
+	nav     *Navigator
+	parts   []string
+	levels  []tplTreeDataLevel
+	err     error
+	cursor  int
+	scroll  int
+	records []difflib.DiffRecord
+	opts    viewOptions
+}
+
+// RunTerminal shows the navigator full-screen in the terminal until the user
+// quits. The layout and the keys are the same as in the web interface; q
+// quits.
+func (nav *Navigator) RunTerminal() error {
+	err := termbox.Init()
+	if err != nil {
+		return err
+	}
+	defer termbox.Close()
+	termbox.SetInputMode(termbox.InputEsc)
+
+	t := &terminalView{nav: nav}
+	t.open(nil)
+	for {
+		t.draw()
+		switch ev := termbox.PollEvent(); ev.Type {
+		case termbox.EventKey:
+			if !t.handleKey(ev) {
+				return nil
+			}
+		case termbox.EventError:
+			return ev.Err
+		}
+	}
+}
+
+func (t *terminalView) path() string {
+	return "/" + strings.Join(t.parts, "/")
+}
+
+// open makes parts the open path and rebuilds its levels.
+func (t *terminalView) open(parts []string) {
+	t.parts = parts
+	t.levels, t.err = t.nav.makeTplLevels(t.path(), t.opts)
+	t.cursor, t.scroll = 0, 0
+	t.records = nil
+	if last := t.last(); last != nil && last.Body != "" {
+		t.records = parseDiff(last.Body)
+	}
+}
+
+func (t *terminalView) last() *tplTreeDataLevel {
+	if len(t.levels) == 0 {
+		return nil
+	}
+	return &t.levels[len(t.levels)-1]
+}
+
+// fileOpen reports whether the last level is a file rather than a folder.
+func (t *terminalView) fileOpen() bool {
+	last := t.last()
+	return last != nil && !last.IsDir
+}
+
+func (t *terminalView) handleKey(ev termbox.Event) bool {
+	last := t.last()
+	_, height := termbox.Size()
+	page := height - 2
+
+	switch {
+	case ev.Key == termbox.KeyCtrlC || ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+		return false
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		if t.fileOpen() {
+			t.scrollBy(1)
+		} else if last != nil && t.cursor < len(last.Entries)-1 {
+			t.cursor++
+		}
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		if t.fileOpen() {
+			t.scrollBy(-1)
+		} else if t.cursor > 0 {
+			t.cursor--
+		}
+	case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeySpace:
+		t.scrollBy(page)
+	case ev.Key == termbox.KeyPgup:
+		t.scrollBy(-page)
+	case ev.Key == termbox.KeyEnter || ev.Key == termbox.KeyArrowRight || ev.Ch == 'l':
+		if !t.fileOpen() && last != nil && t.cursor < len(last.Entries) {
+			t.open(append(t.parts[:len(t.parts):len(t.parts)], last.Entries[t.cursor].Name))
+		}
+	case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2 ||
+		ev.Key == termbox.KeyArrowLeft || ev.Ch == 'h':
+		if len(t.parts) > 0 {
+			name := t.parts[len(t.parts)-1]
+			t.open(t.parts[:len(t.parts)-1])
+			t.selectEntry(name)
+		}
+	case ev.Ch == 'n':
+		t.jumpToChange(1)
+	case ev.Ch == 'p':
+		t.jumpToChange(-1)
+	case ev.Ch == ']':
+		t.jumpToChangedFile(1)
+	case ev.Ch == '[':
+		t.jumpToChangedFile(-1)
+	case ev.Ch == 'g':
+		t.opts.ShowGenerated = !t.opts.ShowGenerated
+		cursor := t.cursor
+		t.open(t.parts)
+		t.cursor = cursor
+	}
+
+	return true
+}
+
+func (t *terminalView) selectEntry(name string) {
+	if last := t.last(); last != nil {
+		for i, e := range last.Entries {
+			if e.Name == name {
+				t.cursor = i
+			}
+		}
+	}
+}
+
+func (t *terminalView) scrollBy(n int) {
+	t.scroll += n
+	if t.scroll > len(t.records)-1 {
+		t.scroll = len(t.records) - 1
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+// jumpToChange scrolls the open file to the start of the next or previous
+// run of changed lines.
+func (t *terminalView) jumpToChange(dir int) {
+	top := t.scroll + termContext
+	if top > len(t.records) {
+		// Scrolled to the end, look back from the last line.
+		top = len(t.records)
+	}
+	for i := top + dir; i >= 0 && i < len(t.records); i += dir {
+		if t.records[i].Delta != difflib.Common && (i == 0 || t.records[i-1].Delta == difflib.Common) {
+			t.scroll = i - termContext
+			t.scrollBy(0)
+			return
+		}
+	}
+}
+
+// jumpToChangedFile opens the next or previous file changed by the patch. If
+// a folder is open, it goes to its first changed file instead.
+func (t *terminalView) jumpToChangedFile(dir int) {
+	files := t.nav.ChangedFiles()
+	if len(files) == 0 {
+		return
+	}
+
+	current := strings.Join(t.parts, "/")
+	next := files[0]
+	if dir < 0 {
+		next = files[len(files)-1]
+	}
+	for i, f := range files {
+		if f == current {
+			next = files[(i+dir+len(files))%len(files)]
+			break
+		}
+		if current != "" && strings.HasPrefix(f, current+"/") {
+			next = f
+			break
+		}
+	}
+
+	t.open(strings.Split(next, "/"))
+}
+
+func (t *terminalView) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	width, height := termbox.Size()
+
+	// Folder columns, dropping the leftmost ones if they don't fit.
+	folders := len(t.levels)
+	if t.fileOpen() {
+		folders--
+	}
+	bodyWidth := 0
+	if t.fileOpen() {
+		bodyWidth = termMinBodyWidth
+	}
+	first := 0
+	for first < folders-1 && (folders-first)*termColumnWidth+bodyWidth > width {
+		first++
+	}
+
+	x := 0
+	for i := first; i < folders; i++ {
+		t.drawFolder(x, height-1, &t.levels[i], i == len(t.levels)-1)
+		x += termColumnWidth
+	}
+	if t.fileOpen() {
+		t.drawFile(x, width-x, height-1, t.last())
+	}
+
+	status := " " + t.path()
+	if t.err != nil && t.err != errBadPath {
+		status += "  (" + t.err.Error() + ")"
+	}
+	status += "  —  j/k move, enter/h open/close, n/p changes, ]/[ files, g generated, q quit"
+	termPrint(0, height-1, width, status, termbox.ColorDefault|termbox.AttrReverse, termbox.ColorDefault)
+
+	termbox.Flush()
+}
+
+func (t *terminalView) drawFolder(x, height int, level *tplTreeDataLevel, isLast bool) {
+	selected := -1
+	for i, e := range level.Entries {
+		if e.IsOpen || (isLast && i == t.cursor) {
+			selected = i
+		}
+	}
+	offset := 0
+	if selected >= height {
+		offset = selected - height + 1
+	}
+
+	for y := 0; y < height && offset+y < len(level.Entries); y++ {
+		i := offset + y
+		e := level.Entries[i]
+
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if i == selected {
+			fg |= termbox.AttrReverse
+		}
+		if e.IsDir {
+			fg |= termbox.AttrBold
+		}
+
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		stats := ""
+		if e.Additions+e.Deletions > 0 {
+			stats = fmt.Sprintf("+%d -%d", e.Additions, e.Deletions)
+		} else if t.nav.Changes[strings.TrimPrefix(level.Path+"/"+e.Name, "/")] != nil {
+			// Changed, but not line by line: mode, rename, binary...
+			name += " *"
+		}
+
+		termPrint(x, y, termColumnWidth-1, strings.Repeat(" ", termColumnWidth-1), fg, bg)
+		termPrint(x, y, termColumnWidth-2-len(stats), " "+name, fg, bg)
+		if stats != "" {
+			sx := x + termColumnWidth - 1 - len(stats)
+			adds := fmt.Sprintf("+%d", e.Additions)
+			termPrint(sx, y, len(adds), adds, fg|termbox.ColorGreen, bg)
+			termPrint(sx+len(adds), y, len(stats)-len(adds), stats[len(adds):], fg|termbox.ColorRed, bg)
+		}
+	}
+}
+
+func (t *terminalView) drawFile(x, width, height int, level *tplTreeDataLevel) {
+	y := 0
+	line := func(s string, fg termbox.Attribute) {
+		if y < height {
+			termPrint(x+1, y, width-1, s, fg, termbox.ColorDefault)
+			y++
+		}
+	}
+
+	if level.Stats != nil {
+		if notes := textNotes(level.Stats); notes != "" {
+			line(notes, termbox.ColorYellow)
+		}
+	}
+
+	switch {
+	case level.Error != nil:
+		line("error: "+level.Error.Error(), termbox.ColorRed)
+	case level.Binary != nil:
+		line(binaryLine(level), termbox.ColorDefault)
+	case level.Stats != nil && level.Kind != "" && !t.opts.ShowGenerated:
+		line("This file is "+level.Kind+"; g shows its diff.", termbox.ColorDefault)
+	default:
+		for _, rec := range t.records[t.scroll:] {
+			switch {
+			case level.Stats == nil:
+				line("  "+rec.Payload, termbox.ColorDefault)
+			case rec.Delta == difflib.RightOnly:
+				line("+ "+rec.Payload, termbox.ColorGreen)
+			case rec.Delta == difflib.LeftOnly:
+				line("- "+rec.Payload, termbox.ColorRed)
+			default:
+				line("  "+rec.Payload, termbox.ColorDefault)
+			}
+		}
+	}
+}
+
+// termPrint writes s at x, y, cut to width cells. Tabs are expanded.
+func termPrint(x, y, width int, s string, fg, bg termbox.Attribute) {
+	s = strings.Replace(s, "\t", "    ", -1)
+	for _, r := range s {
+		if width <= 0 {
+			return
+		}
+		termbox.SetCell(x, y, r, fg, bg)
+		x++
+		width--
+	}
+}
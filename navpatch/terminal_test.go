@@ -0,0 +1,117 @@
+package navpatch
+
+import (
+	"github.com/nsf/termbox-go"
+	. "gopkg.in/check.v1"
+)
+
+// A patch that changes lines 5 and 25 of long.txt, and foo/b.go.
+const terminalPatch = `diff --git a/foo/b.go b/foo/b.go
+index 1111111..2222222 100644
+--- a/foo/b.go
++++ b/foo/b.go
+@@ -1,3 +1,3 @@
+ package foo
+ 
+-func B() {}
++func B2() {}
+diff --git a/long.txt b/long.txt
+index 1111111..2222222 100644
+--- a/long.txt
++++ b/long.txt
+@@ -4,3 +4,3 @@
+ line 4
+-line 5
++line five
+ line 6
+@@ -24,3 +24,3 @@
+ line 24
+-line 25
++line twenty-five
+ line 26
+`
+
+func testTerminal(c *C) *terminalView {
+	t := &terminalView{nav: testNavigator(c, longFileTree, terminalPatch)}
+	t.open(nil)
+	return t
+}
+
+func key(k termbox.Key) termbox.Event { return termbox.Event{Type: termbox.EventKey, Key: k} }
+func char(ch rune) termbox.Event      { return termbox.Event{Type: termbox.EventKey, Ch: ch} }
+
+func (s *PatchS) TestTerminalOpenClose(c *C) {
+	t := testTerminal(c)
+	t.selectEntry("long.txt")
+	cursor := t.cursor
+	c.Assert(t.last().Entries[cursor].Name, Equals, "long.txt")
+
+	c.Assert(t.handleKey(key(termbox.KeyEnter)), Equals, true)
+	c.Assert(t.path(), Equals, "/long.txt")
+	c.Assert(t.fileOpen(), Equals, true)
+
+	t.handleKey(key(termbox.KeyBackspace))
+	c.Assert(t.path(), Equals, "/")
+	c.Assert(t.fileOpen(), Equals, false)
+	c.Assert(t.cursor, Equals, cursor)
+
+	t.selectEntry("foo")
+	t.handleKey(char('l'))
+	c.Assert(t.path(), Equals, "/foo")
+	t.handleKey(char('j'))
+	c.Assert(t.last().Entries[t.cursor].Name, Equals, "b.go")
+	t.handleKey(char('j'))
+	c.Assert(t.last().Entries[t.cursor].Name, Equals, "b.go")
+
+	// Toggling generated files keeps the cursor.
+	t.handleKey(char('g'))
+	c.Assert(t.opts.ShowGenerated, Equals, true)
+	c.Assert(t.last().Entries[t.cursor].Name, Equals, "b.go")
+
+	t.handleKey(char('h'))
+	c.Assert(t.last().Entries[t.cursor].Name, Equals, "foo")
+
+	c.Assert(t.handleKey(char('q')), Equals, false)
+}
+
+func (s *PatchS) TestTerminalJumpToChangedFile(c *C) {
+	t := testTerminal(c)
+	t.handleKey(char('['))
+	c.Assert(t.path(), Equals, "/long.txt")
+
+	t = testTerminal(c)
+	for _, want := range []string{"/foo/b.go", "/long.txt", "/foo/b.go"} {
+		t.handleKey(char(']'))
+		c.Assert(t.path(), Equals, want)
+	}
+	t.handleKey(char('['))
+	c.Assert(t.path(), Equals, "/long.txt")
+
+	// From a folder, its first changed file.
+	t.open([]string{"foo"})
+	t.jumpToChangedFile(-1)
+	c.Assert(t.path(), Equals, "/foo/b.go")
+}
+
+func (s *PatchS) TestTerminalJumpToChange(c *C) {
+	t := testTerminal(c)
+	t.open([]string{"long.txt"})
+	c.Assert(t.records, HasLen, 32)
+
+	// Changes start at records 4 and 25, shown termContext lines down.
+	for _, want := range []int{1, 22, 22} {
+		t.handleKey(char('n'))
+		c.Assert(t.scroll, Equals, want)
+	}
+	for _, want := range []int{1, 1} {
+		t.handleKey(char('p'))
+		c.Assert(t.scroll, Equals, want)
+	}
+
+	t.scrollBy(100)
+	c.Assert(t.scroll, Equals, 31)
+	t.handleKey(char('n'))
+	c.Assert(t.scroll, Equals, 31)
+	t.handleKey(char('p'))
+	c.Assert(t.scroll, Equals, 22)
+}
@@ -0,0 +1,193 @@
+package navpatch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/codereview/patch"
+)
+
+// ANSI escape sequences used when colors are asked for.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// wantsText reports whether the request asks for plain text, either
+// explicitly with format=text or by coming from a command-line HTTP client.
+func wantsText(req *http.Request) bool {
+	switch req.URL.Query().Get("format") {
+	case "text":
+		return true
+	case "html":
+		return false
+	}
+	ua := req.Header.Get("User-Agent")
+	return strings.HasPrefix(ua, "curl/") || strings.HasPrefix(ua, "Wget/")
+}
+
+// serveText serves the folder or file at path as plain text. Folders list
+// their entries with their stats; changed files are shown as a unified diff.
+// With color=1, additions, deletions and headers are colored with ANSI escape
+// sequences.
+func (nav *Navigator) serveText(w http.ResponseWriter, req *http.Request, path string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	q := req.URL.Query()
+	color := q.Get("color") == "1" || q.Get("color") == "true" || q.Get("color") == "always"
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	opts := viewOptionsFromRequest(req)
+	levels, err := nav.makeTplLevels(path, opts)
+	if err == errBadPath || len(levels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "not found:", path)
+		return
+	} else if err != nil && err != patch.ErrPatchFailure {
+		log.Println(path, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	parts := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	current := strings.Join(parts, "/")
+	level := levels[len(levels)-1]
+
+	var out bytes.Buffer
+	fmt.Fprintln(&out, paint(ansiBold, "/"+current))
+	if stats := nav.Changes[current]; stats != nil {
+		if notes := textNotes(stats); notes != "" {
+			fmt.Fprintln(&out, notes)
+		}
+	}
+	fmt.Fprintln(&out)
+
+	switch {
+	case level.Error != nil:
+		fmt.Fprintln(&out, "error:", level.Error)
+	case level.Binary != nil:
+		fmt.Fprintln(&out, binaryLine(&level))
+	case level.Body != "" && nav.Changes[current] == nil:
+		for _, rec := range parseDiff(level.Body) {
+			fmt.Fprintln(&out, rec.Payload)
+		}
+	case level.Body != "" && level.Kind != "" && !opts.ShowGenerated:
+		fmt.Fprintf(&out, "This file is %s; generated=1 shows its diff.\n", level.Kind)
+	case level.Body != "":
+		for _, h := range makeJSONHunks(level.Body, opts.Context) {
+			fmt.Fprintln(&out, paint(ansiCyan, fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)))
+			for _, l := range h.Lines {
+				switch l.Kind {
+				case lineAddition:
+					fmt.Fprintln(&out, paint(ansiGreen, "+"+l.Text))
+				case lineDeletion:
+					fmt.Fprintln(&out, paint(ansiRed, "-"+l.Text))
+				default:
+					fmt.Fprintln(&out, " "+l.Text)
+				}
+			}
+		}
+	case level.IsDir:
+		width := 0
+		for _, e := range level.Entries {
+			if len(e.Name)+1 > width {
+				width = len(e.Name) + 1
+			}
+		}
+		for _, e := range level.Entries {
+			name := e.Name
+			if e.IsDir {
+				name += "/"
+			}
+			line := fmt.Sprintf("%-*s", width, name)
+			if stats := opts.shownStats(nav.Changes[strings.TrimPrefix(level.Path+"/"+e.Name, "/")]); stats != nil {
+				line += "  " + paint(ansiGreen, fmt.Sprintf("%+5d", stats.Additions))
+				line += " " + paint(ansiRed, fmt.Sprintf("%5s", fmt.Sprintf("-%d", stats.Deletions)))
+				if notes := textNotes(stats); notes != "" {
+					line += "  " + notes
+				}
+			}
+			fmt.Fprintln(&out, strings.TrimRight(line, " "))
+		}
+	}
+
+	w.Write(out.Bytes())
+}
+
+// textNotes describes what happened to a file other than line changes.
+func textNotes(s *DiffStats) string {
+	var notes []string
+	switch {
+	case s.Added:
+		notes = append(notes, "added")
+	case s.Removed:
+		notes = append(notes, "removed")
+	case s.Renamed:
+		notes = append(notes, "renamed from "+s.OldPath)
+	case s.Copied:
+		notes = append(notes, "copied from "+s.OldPath)
+	}
+	if s.ModeChanged() {
+		notes = append(notes, fmt.Sprintf("mode %06o → %06o", s.OldMode, s.NewMode))
+	}
+	if s.Generated {
+		notes = append(notes, "generated")
+	}
+	if s.Vendored {
+		notes = append(notes, "vendored")
+	}
+	if s.MovedIn > 0 {
+		notes = append(notes, fmt.Sprintf("%d lines moved in", s.MovedIn))
+	}
+	if s.MovedOut > 0 {
+		notes = append(notes, fmt.Sprintf("%d lines moved out", s.MovedOut))
+	}
+	if s.Binary {
+		note := "binary"
+		if sizes := binarySizes(s); sizes != "" {
+			note += ", " + sizes
+		}
+		notes = append(notes, note)
+	}
+	return strings.Join(notes, ", ")
+}
+
+// binarySizes tells the sizes of a binary file before and after the patch,
+// leaving out those it doesn't tell.
+func binarySizes(s *DiffStats) string {
+	// Sizes are negative if the patch doesn't tell them.
+	var sizes []string
+	if s.OldSize >= 0 && !s.Added {
+		sizes = append(sizes, strconv.Itoa(s.OldSize))
+	}
+	if s.NewSize >= 0 && !s.Removed {
+		sizes = append(sizes, strconv.Itoa(s.NewSize))
+	}
+	if len(sizes) == 0 {
+		return ""
+	}
+	return strings.Join(sizes, " → ") + " bytes"
+}
+
+// binaryLine describes the binary file of level. The contents of changed
+// files may not be in the patch, so their sizes come from it instead.
+func binaryLine(level *tplTreeDataLevel) string {
+	if s := level.Stats; s != nil && s.Binary {
+		if sizes := binarySizes(s); sizes != "" {
+			return "Binary file (" + sizes + ")."
+		}
+		return "Binary file of unknown size."
+	}
+	return fmt.Sprintf("Binary file (%d → %d bytes).", len(level.Binary.Old), len(level.Binary.New))
+}
@@ -0,0 +1,34 @@
+package navpatch
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *PatchS) TestTextNotesBinarySizes(c *C) {
+	for _, t := range []struct {
+		stats DiffStats
+		notes string
+	}{
+		{DiffStats{Binary: true, OldSize: 105, NewSize: 106}, "binary, 105 → 106 bytes"},
+		{DiffStats{Binary: true, Added: true, NewSize: 306}, "added, binary, 306 bytes"},
+		{DiffStats{Binary: true, Removed: true, OldSize: 306, NewSize: -1}, "removed, binary, 306 bytes"},
+		{DiffStats{Binary: true, OldSize: 105, NewSize: -1}, "binary, 105 bytes"},
+		{DiffStats{Binary: true, OldSize: -1, NewSize: -1}, "binary"},
+	} {
+		c.Assert(textNotes(&t.stats), Equals, t.notes)
+	}
+}
+
+func (s *PatchS) TestBinaryWithoutData(c *C) {
+	nav := testNavigator(c, testTree, `diff --git a/x.bin b/x.bin
+new file mode 100644
+index 0000000..1111111
+Binary files /dev/null and b/x.bin differ
+`)
+
+	body := serveTest(nav, "/x.bin").Body.String()
+	c.Assert(body, Matches, `(?s).*Binary file added \(unknown size\)\..*`)
+	c.Assert(body, Not(Matches), `(?s).*0 bytes.*`)
+
+	c.Assert(serveTest(nav, "/x.bin?format=text").Body.String(), Matches, `(?s).*Binary file of unknown size\..*`)
+}